@@ -3,12 +3,14 @@ package ast
 import (
 	"bytes"
 	"strings"
-	"../token"
+	"monkey/token"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position		// position of the first character of the node
+	End() token.Position		// position right after the last character of the node
 }
 
 type Statement interface {
@@ -34,6 +36,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -58,6 +74,14 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+func (ls *LetStatement) Pos() token.Position {return ls.Token.Pos}
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -86,6 +110,9 @@ func (id *Identifier) TokenLiteral() string {
 	return id.Token.Literal
 }
 
+func (id *Identifier) Pos() token.Position {return id.Token.Pos}
+func (id *Identifier) End() token.Position {return id.Token.End}
+
 func (id *Identifier) String() string {
 	return id.Value
 }
@@ -101,6 +128,14 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Pos() token.Position {return rs.Token.Pos}
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
+
 func (rs *ReturnStatement) String() string{
 	var out bytes.Buffer
 
@@ -126,6 +161,14 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Position {return es.Token.Pos}
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
+
 func (es *ExpressionStatement) String() string {
 	var out bytes.Buffer
 
@@ -144,6 +187,19 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode() {}
 func (il *IntegerLiteral) TokenLiteral() string  {return il.Token.Literal}
 func (il *IntegerLiteral) String()	string	     {return il.Token.Literal}
+func (il *IntegerLiteral) Pos() token.Position  {return il.Token.Pos}
+func (il *IntegerLiteral) End() token.Position  {return il.Token.End}
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+func (fl *FloatLiteral) TokenLiteral() string  {return fl.Token.Literal}
+func (fl *FloatLiteral) String()	string	     {return fl.Token.Literal}
+func (fl *FloatLiteral) Pos() token.Position   {return fl.Token.Pos}
+func (fl *FloatLiteral) End() token.Position   {return fl.Token.End}
 
 type PrefixExpression struct {
 	Token token.Token 			// The prefix token, e.g. !
@@ -153,6 +209,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode() {}
 func (pe *PrefixExpression) TokenLiteral() string {return pe.Token.Literal}
+func (pe *PrefixExpression) Pos() token.Position {return pe.Token.Pos}
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -176,6 +239,18 @@ func (ie *InfixExpression) TokenLiteral() string {
 }
 
 func (ie *InfixExpression) expressionNode() {}
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 
 func (ie *InfixExpression) String() string{
 	var out bytes.Buffer
@@ -199,6 +274,8 @@ type Boolean struct {
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string {return b.Token.Literal}
 func (b *Boolean) expressionNode() {}
+func (b *Boolean) Pos() token.Position {return b.Token.Pos}
+func (b *Boolean) End() token.Position {return b.Token.End}
 
 type IfExpression struct {
 	Token 		token.Token			// If
@@ -209,6 +286,13 @@ type IfExpression struct {
 
 func (ie *IfExpression) TokenLiteral() string {return ie.Token.Literal}
 func (ie *IfExpression) expressionNode() {}
+func (ie *IfExpression) Pos() token.Position {return ie.Token.Pos}
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -228,10 +312,13 @@ func (ie *IfExpression) String() string {
 type BlockStatement struct{
 	Token token.Token		// The "{" token
 	Statements []Statement
+	Rbrace token.Token		// The "}" token
 }
 
 func (bs *BlockStatement) TokenLiteral() string {return bs.Token.Literal}
 func (bs *BlockStatement) expressionNode() {}
+func (bs *BlockStatement) Pos() token.Position {return bs.Token.Pos}
+func (bs *BlockStatement) End() token.Position {return bs.Rbrace.End}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -246,10 +333,13 @@ type FunctionLiteral struct {
 	Token 			token.Token			// The 'fn' token
 	Parameters		[]*Identifier
 	Body			*BlockStatement
+	Name			string				// Name of the let binding, if there is one
 }
 
 func (fl *FunctionLiteral) TokenLiteral() string {return fl.Token.Literal}
 func (fl *FunctionLiteral) expressionNode() {}
+func (fl *FunctionLiteral) Pos() token.Position {return fl.Token.Pos}
+func (fl *FunctionLiteral) End() token.Position {return fl.Body.End()}
 func (fl *FunctionLiteral) String() string{
 	var out bytes.Buffer
 
@@ -268,13 +358,21 @@ func (fl *FunctionLiteral) String() string{
 }
 
 type CallExpression struct  {
-	Token 		token.Token
+	Token 		token.Token			// The "(" token
 	Function 	Expression			//Identifier or FunctionLiteral
 	Arguments 	[]Expression
+	Rparen		token.Token			// The ")" token
 }
 
 func (ce *CallExpression) expressionNode() {}
 func (ce *CallExpression) TokenLiteral() string{return ce.Token.Literal}
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+func (ce *CallExpression) End() token.Position {return ce.Rparen.End}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -299,14 +397,19 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode(){}
 func (sl *StringLiteral) TokenLiteral() string	{return sl.Token.Literal}
 func (sl *StringLiteral) String() string 		{return sl.Token.Literal}
+func (sl *StringLiteral) Pos() token.Position	{return sl.Token.Pos}
+func (sl *StringLiteral) End() token.Position	{return sl.Token.End}
 
 type ArrayLiteral struct {
-	Token token.Token
+	Token token.Token		// The [ token
 	Elements []Expression
+	Rbracket token.Token	// The ] token, or the name in h.name
 }
 
 func (al *ArrayLiteral) expressionNode() {}
 func (al *ArrayLiteral) TokenLiteral() string {return al.Token.Literal}
+func (al *ArrayLiteral) Pos() token.Position {return al.Token.Pos}
+func (al *ArrayLiteral) End() token.Position {return al.Rbracket.End}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -327,10 +430,18 @@ type IndexExpression struct {
 	Token token.Token		// The [ token
 	Left  Expression
 	Index Expression
+	Rbracket token.Token	// The ] token
 }
 
 func (ie *IndexExpression) TokenLiteral() string {return ie.Token.Literal}
 func (ie *IndexExpression) expressionNode() {}
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *IndexExpression) End() token.Position {return ie.Rbracket.End}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -343,24 +454,226 @@ func (ie *IndexExpression) String() string {
 
 	return out.String()
 }
+
+// s[low:high], either bound can be left out
+type SliceExpression struct {
+	Token token.Token		// The [ token
+	Left  Expression
+	Low   Expression		// nil from the beginning
+	High  Expression		// nil up to the end
+	Rbracket token.Token	// The ] token
+}
+
+func (se *SliceExpression) TokenLiteral() string {return se.Token.Literal}
+func (se *SliceExpression) expressionNode() {}
+func (se *SliceExpression) Pos() token.Position {return se.Left.Pos()}
+func (se *SliceExpression) End() token.Position {return se.Rbracket.End}
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("]")
+	out.WriteString(")")
+
+	return out.String()
+}
  
 type HashLiteral struct {
-	Token 	token.Token
+	Token 	token.Token		// The { token
 	Pairs	map[Expression]Expression
+	Keys	[]Expression	// keys of Pairs in the order of the source
+	Rbrace	token.Token		// The } token
 }
 
 func (hl *HashLiteral) TokenLiteral() string {return hl.Token.Literal}
 func (hl *HashLiteral) expressionNode() {}
+func (hl *HashLiteral) Pos() token.Position {return hl.Token.Pos}
+func (hl *HashLiteral) End() token.Position {return hl.Rbrace.End}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String() + ":" + value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String() + ":" + hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
 	out.WriteString("}")
 	return out.String()
-}
\ No newline at end of file
+}
+
+// while (cond) { ... }
+type WhileStatement struct {
+	Token		token.Token		// The 'while' token
+	Condition	Expression
+	Body		*BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+func (ws *WhileStatement) TokenLiteral() string {return ws.Token.Literal}
+func (ws *WhileStatement) Pos() token.Position {return ws.Token.Pos}
+func (ws *WhileStatement) End() token.Position {return ws.Body.End()}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while ")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// for (x in iterable) { ... }
+type ForStatement struct {
+	Token		token.Token		// The 'for' token
+	Variable	*Identifier
+	Iterable	Expression
+	Body		*BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+func (fs *ForStatement) TokenLiteral() string {return fs.Token.Literal}
+func (fs *ForStatement) Pos() token.Position {return fs.Token.Pos}
+func (fs *ForStatement) End() token.Position {return fs.Body.End()}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode() {}
+func (bs *BreakStatement) TokenLiteral() string {return bs.Token.Literal}
+func (bs *BreakStatement) String() string {return bs.Token.Literal + ";"}
+func (bs *BreakStatement) Pos() token.Position {return bs.Token.Pos}
+func (bs *BreakStatement) End() token.Position {return bs.Token.End}
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+func (cs *ContinueStatement) TokenLiteral() string {return cs.Token.Literal}
+func (cs *ContinueStatement) String() string {return cs.Token.Literal + ";"}
+func (cs *ContinueStatement) Pos() token.Position {return cs.Token.Pos}
+func (cs *ContinueStatement) End() token.Position {return cs.Token.End}
+
+// x = 5, x += 1 or arr[0] = x
+type AssignExpression struct {
+	Token		token.Token		// The assignment operator token
+	Target		Expression		// Identifier or IndexExpression
+	Operator	string
+	Value		Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+func (ae *AssignExpression) TokenLiteral() string {return ae.Token.Literal}
+func (ae *AssignExpression) Pos() token.Position {return ae.Target.Pos()}
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" ")
+	out.WriteString(ae.Operator)
+	out.WriteString(" ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+type ThrowStatement struct {
+	Token	token.Token		// The 'throw' token
+	Value	Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+func (ts *ThrowStatement) TokenLiteral() string {return ts.Token.Literal}
+func (ts *ThrowStatement) Pos() token.Position {return ts.Token.Pos}
+func (ts *ThrowStatement) End() token.Position {
+	if ts.Value != nil {
+		return ts.Value.End()
+	}
+	return ts.Token.End
+}
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("throw ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// try { ... } catch (e) { ... } finally { ... }, catch or finally may be left out
+type TryExpression struct {
+	Token		token.Token		// The 'try' token
+	Block		*BlockStatement
+	CatchParam	*Identifier		// nil without catch
+	Catch		*BlockStatement
+	Finally		*BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+func (te *TryExpression) TokenLiteral() string {return te.Token.Literal}
+func (te *TryExpression) Pos() token.Position {return te.Token.Pos}
+func (te *TryExpression) End() token.Position {
+	if te.Finally != nil {
+		return te.Finally.End()
+	}
+	if te.Catch != nil {
+		return te.Catch.End()
+	}
+	return te.Block.End()
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(te.CatchParam.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
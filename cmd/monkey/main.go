@@ -0,0 +1,89 @@
+package main
+
+import (
+	   "flag"
+	   "fmt"
+	   "io/ioutil"
+       "os"
+       "os/user"
+       "monkey/evaluator"
+       "monkey/repl"
+)
+
+var engine = flag.String("engine", repl.ENGINE_EVAL, "use 'eval' (tree-walker) or 'vm' (bytecode)")
+var expr = flag.String("e", "", "run the program `code` and print its value")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: monkey [flags] [script.mk | -] [args...]\n\n")
+	fmt.Fprintf(os.Stderr, "Without a script, programs are read from a pipe or typed into the console.\n")
+	fmt.Fprintf(os.Stderr, "The arguments after the script are the strings of the global args.\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *engine != repl.ENGINE_EVAL && *engine != repl.ENGINE_VM {
+		fmt.Fprintf(os.Stderr, "unknown engine %q, use eval or vm\n", *engine)
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	switch {
+		case *expr != "":
+			os.Exit(runScript("-e", *expr, args, true))
+		case len(args) > 0:
+			src, err := readScript(args[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			os.Exit(runScript(args[0], src, args[1:], false))
+		case !isTerminal(os.Stdin):
+			src, err := readScript("-")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			os.Exit(runScript("", src, nil, false))
+	}
+
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Hello %s! This is the Monkey Programming Language!\n", user.Username)
+	fmt.Printf("Feel free to type in commands\n")
+	repl.StartWithEngine(os.Stdin, os.Stdout, *engine)
+}
+
+// Run the program and return the exit status: 0 when it ran to the end,
+// 1 when it has syntax errors or an error that was not caught
+func runScript(filename string, src string, args []string, printResult bool) int {
+	result, ok := repl.RunScript(filename, src, args, os.Stderr, *engine)
+	if !ok {
+		return 1
+	}
+	if printResult && result != nil && result != evaluator.NULL {
+		fmt.Println(result.Inspect())
+	}
+	return 0
+}
+
+// The source of a script file, - is standard input
+func readScript(filename string) (string, error) {
+	var src []byte
+	var err error
+	if filename == "-" {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(filename)
+	}
+	return string(src), err
+}
+
+// Whether f is a console rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
@@ -0,0 +1,244 @@
+package code
+
+// Instruction set of the Monkey virtual machine.
+// Every instruction is one opcode byte followed by its operands,
+// operands are big endian encoded.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota	// push constants[operand]
+	OpPop						// pop the top of the stack
+	OpDup						// push a copy of the top operand elements
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpGreaterEqual
+	OpLessEqual
+
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpJump						// jump to operand
+	OpJumpNotTruthy				// pop, jump to operand if not truthy
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+	OpSetFree
+	OpCaptureLocal				// box a local in a cell shared with closures, push the cell
+	OpCaptureFree				// push the cell of a free variable
+	OpCurrentClosure
+
+	OpArray						// build an array from the top operand elements
+	OpHash						// build a hash from the top operand elements (key, value, ...)
+	OpIndex
+	OpSetIndex					// pop a value, index and container, store the value, push it back
+	OpSlice						// pop the high and low bounds and the container, push the part between them
+
+	OpCall						// call the function below the top operand arguments
+	OpReturnValue				// return the top of the stack
+	OpReturn					// return null
+
+	OpClosure					// wrap constants[operand1] with operand2 free variables
+
+	OpIter						// replace the top with an iterator over its items
+	OpIterNext					// pop an iterator, push its next item or jump to operand when done
+
+	OpSetupCatch				// errors until OpPopTry jump to operand with the caught error value pushed
+	OpSetupFinally				// errors until OpPopTry jump to operand with the error itself pushed
+	OpPopTry					// remove the handler of the innermost try
+	OpThrow						// pop a value and raise it as an error
+)
+
+type Definition struct {
+	Name 			string
+	OperandWidths	[]int		// number of bytes of each operand
+}
+
+var definitions = map[Opcode]*Definition {
+	OpConstant:			{"OpConstant", []int{2}},
+	OpPop:				{"OpPop", []int{}},
+	OpAdd:				{"OpAdd", []int{}},
+	OpSub:				{"OpSub", []int{}},
+	OpMul:				{"OpMul", []int{}},
+	OpDiv:				{"OpDiv", []int{}},
+	OpMod:				{"OpMod", []int{}},
+	OpPow:				{"OpPow", []int{}},
+	OpEqual:			{"OpEqual", []int{}},
+	OpNotEqual:			{"OpNotEqual", []int{}},
+	OpGreaterThan:		{"OpGreaterThan", []int{}},
+	OpLessThan:			{"OpLessThan", []int{}},
+	OpGreaterEqual:		{"OpGreaterEqual", []int{}},
+	OpLessEqual:		{"OpLessEqual", []int{}},
+	OpMinus:			{"OpMinus", []int{}},
+	OpBang:				{"OpBang", []int{}},
+	OpTrue:				{"OpTrue", []int{}},
+	OpFalse:			{"OpFalse", []int{}},
+	OpNull:				{"OpNull", []int{}},
+	OpJump:				{"OpJump", []int{2}},
+	OpJumpNotTruthy:	{"OpJumpNotTruthy", []int{2}},
+	OpGetGlobal:		{"OpGetGlobal", []int{2}},
+	OpSetGlobal:		{"OpSetGlobal", []int{2}},
+	OpGetLocal:			{"OpGetLocal", []int{1}},
+	OpSetLocal:			{"OpSetLocal", []int{1}},
+	OpGetFree:			{"OpGetFree", []int{1}},
+	OpSetFree:			{"OpSetFree", []int{1}},
+	OpCaptureLocal:		{"OpCaptureLocal", []int{1}},
+	OpCaptureFree:		{"OpCaptureFree", []int{1}},
+	OpCurrentClosure:	{"OpCurrentClosure", []int{}},
+	OpDup:				{"OpDup", []int{1}},
+	OpArray:			{"OpArray", []int{2}},
+	OpHash:				{"OpHash", []int{2}},
+	OpIndex:			{"OpIndex", []int{}},
+	OpSetIndex:			{"OpSetIndex", []int{}},
+	OpSlice:			{"OpSlice", []int{}},
+	OpCall:				{"OpCall", []int{1}},
+	OpReturnValue:		{"OpReturnValue", []int{}},
+	OpReturn:			{"OpReturn", []int{}},
+	OpClosure:			{"OpClosure", []int{2, 1}},
+	OpIter:				{"OpIter", []int{}},
+	OpIterNext:			{"OpIterNext", []int{2}},
+	OpSetupCatch:		{"OpSetupCatch", []int{2}},
+	OpSetupFinally:		{"OpSetupFinally", []int{2}},
+	OpPopTry:			{"OpPopTry", []int{}},
+	OpThrow:			{"OpThrow", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Encode an opcode and its operands into one instruction
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+			case 2:
+				binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+			case 1:
+				instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+	return instruction
+}
+
+// The first operand that does not fit in its width, Make would cut it off.
+// False if all of them fit
+func Overflow(op Opcode, operands ...int) (int, bool) {
+	def, ok := definitions[op]
+	if !ok {
+		return 0, false
+	}
+	for i, o := range operands {
+		if i < len(def.OperandWidths) && (o < 0 || o >= 1 << (8 * uint(def.OperandWidths[i]))) {
+			return o, true
+		}
+	}
+	return 0, false
+}
+
+// Decode the operands of an instruction, returns them and the bytes read
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+			case 2:
+				operands[i] = int(ReadUint16(ins[offset:]))
+			case 1:
+				operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// Disassemble the instructions, one per line
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i += 1
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+		case 0:
+			return def.Name
+		case 1:
+			return fmt.Sprintf("%s %d", def.Name, operands[0])
+		case 2:
+			return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
@@ -0,0 +1,908 @@
+package compiler
+
+// Compiler lowers the AST into bytecode for the vm
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+)
+
+type Compiler struct {
+	constants	[]object.Object
+	symbolTable	*SymbolTable
+
+	scopes		[]CompilationScope
+	scopeIndex	int
+
+	builtins	*object.Registry		// builtin functions and namespaces names can refer to
+
+	pos			token.Position		// position of the node being compiled
+	err			error				// an operand that did not fit, returned by Compile
+}
+
+// Instructions of the function body being compiled
+type CompilationScope struct {
+	instructions		code.Instructions
+	positions			map[int]token.Position
+	lastInstruction		EmittedInstruction
+	previousInstruction	EmittedInstruction
+	loops				[]*Loop				// loops around the statement being compiled
+	tries				[]*Try				// try blocks around the statement being compiled
+}
+
+// Jump targets of break and continue inside a loop
+type Loop struct {
+	continuePos		int
+	breakJumps		[]int		// break jumps, patched once the end of the loop is known
+}
+
+// A try block, or a catch block followed by finally, that return, break
+// and continue have to leave: its handler is removed and finally runs
+type Try struct {
+	finally		*ast.BlockStatement		// nil without finally
+	loopDepth	int						// number of loops around the try
+}
+
+type EmittedInstruction struct {
+	Opcode		code.Opcode
+	Position	int
+}
+
+// The result of the compilation, ready to be run by the vm
+type Bytecode struct {
+	Instructions	code.Instructions
+	Constants		[]object.Object
+	Positions		map[int]token.Position
+}
+
+// Error is returned for programs that cannot be compiled
+type Error struct {
+	Message	string
+	Pos		token.Position
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions: code.Instructions{},
+		positions: make(map[int]token.Position),
+	}
+
+	return &Compiler{
+		constants: []object.Object{},
+		symbolTable: NewSymbolTable(),
+		scopes: []CompilationScope{mainScope},
+		scopeIndex: 0,
+		builtins: evaluator.NewRegistry(),
+	}
+}
+
+// Compile names of builtins to the functions of r instead of the default ones
+func (c *Compiler) SetBuiltins(r *object.Registry) {
+	c.builtins = r
+}
+
+// Keep compiling into existing globals and constants, e.g. line after line in the REPL
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants: c.constants,
+		Positions: c.scopes[c.scopeIndex].positions,
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	// Instructions are attributed to the innermost node that emits them
+	prevPos := c.pos
+	c.pos = node.Pos()
+	defer func() {
+		c.pos = prevPos
+		if err == nil && c.err != nil {
+			err, c.err = c.err, nil
+		}
+	}()
+
+	switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				if err := c.Compile(s); err != nil {
+					return err
+				}
+			}
+
+			// The program evaluates to null unless it ends with an expression
+			n := len(node.Statements)
+			if n > 0 {
+				if _, ok := node.Statements[n-1].(*ast.ExpressionStatement); !ok {
+					c.emit(code.OpNull)
+					c.emit(code.OpPop)
+				}
+			}
+		case *ast.ExpressionStatement:
+			if err := c.Compile(node.Expression); err != nil {
+				return err
+			}
+			c.emit(code.OpPop)
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				if err := c.Compile(s); err != nil {
+					return err
+				}
+			}
+		case *ast.LetStatement:
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+
+			// Defined afterwards, so the value still sees the previous binding
+			symbol := c.symbolTable.Define(node.Name.Value)
+			c.storeSymbol(symbol)
+		case *ast.WhileStatement:
+			return c.compileWhileStatement(node)
+		case *ast.ForStatement:
+			return c.compileForStatement(node)
+		case *ast.BreakStatement:
+			loop := c.currentLoop()
+			if loop == nil {
+				return c.newError("break outside of a loop")
+			}
+			if err := c.leaveTries(len(c.scopes[c.scopeIndex].loops)); err != nil {
+				return err
+			}
+			jumpPos := c.emit(code.OpJump, 9999)
+			loop.breakJumps = append(loop.breakJumps, jumpPos)
+		case *ast.ContinueStatement:
+			loop := c.currentLoop()
+			if loop == nil {
+				return c.newError("continue outside of a loop")
+			}
+			if err := c.leaveTries(len(c.scopes[c.scopeIndex].loops)); err != nil {
+				return err
+			}
+			c.emit(code.OpJump, loop.continuePos)
+		case *ast.ReturnStatement:
+			if err := c.Compile(node.ReturnValue); err != nil {
+				return err
+			}
+			if err := c.leaveTries(0); err != nil {
+				return err
+			}
+			c.emit(code.OpReturnValue)
+		case *ast.ThrowStatement:
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			c.emit(code.OpThrow)
+		case *ast.Identifier:
+			symbol, ok := c.symbolTable.Resolve(node.Value)
+			if ok {
+				c.loadSymbol(symbol)
+				return nil
+			}
+
+			if builtin, ok := c.builtins.Lookup(node.Value); ok {
+				c.emit(code.OpConstant, c.addConstant(builtin))
+				return nil
+			}
+			return c.newError("identifier not found: " + node.Value)
+		case *ast.IntegerLiteral:
+			integer := &object.Integer{Value: node.Value}
+			c.emit(code.OpConstant, c.addConstant(integer))
+		case *ast.FloatLiteral:
+			float := &object.Float{Value: node.Value}
+			c.emit(code.OpConstant, c.addConstant(float))
+		case *ast.StringLiteral:
+			str := &object.String{Value: node.Value}
+			c.emit(code.OpConstant, c.addConstant(str))
+		case *ast.Boolean:
+			if node.Value {
+				c.emit(code.OpTrue)
+			} else {
+				c.emit(code.OpFalse)
+			}
+		case *ast.PrefixExpression:
+			if err := c.Compile(node.Right); err != nil {
+				return err
+			}
+
+			switch node.Operator {
+				case "!":
+					c.emit(code.OpBang)
+				case "-":
+					c.emit(code.OpMinus)
+				default:
+					return c.newError(fmt.Sprintf("unknown operator: %s", node.Operator))
+			}
+		case *ast.InfixExpression:
+			if node.Operator == "&&" || node.Operator == "||" {
+				return c.compileLogicalExpression(node)
+			}
+
+			op, ok := infixOperators[node.Operator]
+			if !ok {
+				return c.newError(fmt.Sprintf("unknown operator: %s", node.Operator))
+			}
+
+			if err := c.Compile(node.Left); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Right); err != nil {
+				return err
+			}
+			c.emit(op)
+		case *ast.IfExpression:
+			return c.compileIfExpression(node)
+		case *ast.TryExpression:
+			return c.compileTryExpression(node)
+		case *ast.AssignExpression:
+			return c.compileAssignExpression(node)
+		case *ast.ArrayLiteral:
+			for _, el := range node.Elements {
+				if err := c.Compile(el); err != nil {
+					return err
+				}
+			}
+			c.emit(code.OpArray, len(node.Elements))
+		case *ast.HashLiteral:
+			// In the order of the source, which the hash keeps
+			for _, k := range node.Keys {
+				if err := c.Compile(k); err != nil {
+					return err
+				}
+				if err := c.Compile(node.Pairs[k]); err != nil {
+					return err
+				}
+			}
+			c.emit(code.OpHash, len(node.Pairs) * 2)
+		case *ast.IndexExpression:
+			if err := c.Compile(node.Left); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Index); err != nil {
+				return err
+			}
+			c.emit(code.OpIndex)
+		case *ast.SliceExpression:
+			if err := c.Compile(node.Left); err != nil {
+				return err
+			}
+			// A bound that is left out is null
+			for _, bound := range []ast.Expression{node.Low, node.High} {
+				if bound == nil {
+					c.emit(code.OpNull)
+				} else if err := c.Compile(bound); err != nil {
+					return err
+				}
+			}
+			c.emit(code.OpSlice)
+		case *ast.FunctionLiteral:
+			return c.compileFunctionLiteral(node)
+		case *ast.CallExpression:
+			if err := c.Compile(node.Function); err != nil {
+				return err
+			}
+
+			for _, a := range node.Arguments {
+				if err := c.Compile(a); err != nil {
+					return err
+				}
+			}
+			c.emit(code.OpCall, len(node.Arguments))
+	}
+	return nil
+}
+
+var infixOperators = map[string]code.Opcode {
+	"+":	code.OpAdd,
+	"-":	code.OpSub,
+	"*":	code.OpMul,
+	"/":	code.OpDiv,
+	"==":	code.OpEqual,
+	"!=":	code.OpNotEqual,
+	">":	code.OpGreaterThan,
+	"<":	code.OpLessThan,
+	">=":	code.OpGreaterEqual,
+	"<=":	code.OpLessEqual,
+	"%":	code.OpMod,
+	"**":	code.OpPow,
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// Bogus offset, patched once we know where the consequence ends
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else {
+		if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// The try value is left on the stack, or the catch value after an error.
+// Without catch, or when the catch block fails, a second handler runs the
+// finally block and throws the error again:
+//
+//	OpSetupCatch handler; try block; OpPopTry; OpJump end
+//	handler: store e; OpSetupFinally rethrow; catch block; OpPopTry; OpJump end
+//	rethrow: finally block; OpThrow
+//	end: finally block
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	setupOp := code.OpSetupFinally
+	if node.Catch != nil {
+		setupOp = code.OpSetupCatch
+	}
+	setupPos := c.emit(setupOp, 9999)
+
+	if err := c.compileTryBlock(node.Block, node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpPopTry)
+	endJumps := []int{c.emit(code.OpJump, 9999)}
+
+	// Handlers that run the finally block and throw again
+	rethrowSetups := []int{setupPos}
+
+	if node.Catch != nil {
+		c.changeOperand(setupPos, len(c.currentInstructions()))
+		rethrowSetups = []int{}
+
+		param := c.symbolTable.Define(node.CatchParam.Value)
+		c.storeSymbol(param)
+
+		if node.Finally != nil {
+			rethrowSetups = append(rethrowSetups, c.emit(code.OpSetupFinally, 9999))
+
+			if err := c.compileTryBlock(node.Catch, node.Finally); err != nil {
+				return err
+			}
+			c.emit(code.OpPopTry)
+		} else if err := c.compileBlockValue(node.Catch); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	}
+
+	if node.Finally != nil {
+		for _, pos := range rethrowSetups {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	if node.Finally != nil {
+		return c.Compile(node.Finally)
+	}
+	return nil
+}
+
+// Compile a block protected by a handler, return, break and continue
+// inside it remove the handler and run finally before they leave
+func (c *Compiler) compileTryBlock(block, finally *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	try := &Try{finally: finally, loopDepth: len(scope.loops)}
+	scope.tries = append(scope.tries, try)
+
+	err := c.compileBlockValue(block)
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	return err
+}
+
+// Remove the handlers of the try blocks inside loopDepth loops and run their
+// finally blocks, innermost first. A return leaves all of them with depth 0
+func (c *Compiler) leaveTries(loopDepth int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0 && tries[i].loopDepth >= loopDepth; i-- {
+		c.emit(code.OpPopTry)
+
+		// The finally block is outside of the try, it must not leave it again
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		if tries[i].finally != nil {
+			if err := c.Compile(tries[i].finally); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Jump over the right side when the left one decides the result
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	leftFalsePos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	endJumps := []int{}
+	if node.Operator == "||" {
+		c.emit(code.OpTrue)
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		c.changeOperand(leftFalsePos, len(c.currentInstructions()))
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	rightFalsePos := c.emit(code.OpJumpNotTruthy, 9999)
+	c.emit(code.OpTrue)
+	endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+	if node.Operator == "&&" {
+		c.changeOperand(leftFalsePos, len(c.currentInstructions()))
+	}
+	c.changeOperand(rightFalsePos, len(c.currentInstructions()))
+	c.emit(code.OpFalse)
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// Like the evaluator, compound operators read the current value
+// before the value is evaluated
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	var op code.Opcode
+	if node.Operator != "=" {
+		op = infixOperators[node.Operator[:len(node.Operator)-1]]
+	}
+
+	switch target := node.Target.(type) {
+		case *ast.Identifier:
+			symbol, ok := c.symbolTable.Resolve(target.Value)
+			if !ok {
+				return c.newError("identifier not found: " + target.Value)
+			}
+			if node.Operator != "=" {
+				c.loadSymbol(symbol)
+			}
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			if node.Operator != "=" {
+				c.emit(op)
+			}
+
+			c.storeSymbol(symbol)
+			c.loadSymbol(symbol)
+		case *ast.IndexExpression:
+			if err := c.Compile(target.Left); err != nil {
+				return err
+			}
+			if err := c.Compile(target.Index); err != nil {
+				return err
+			}
+
+			if node.Operator != "=" {
+				c.emit(code.OpDup, 2)
+				c.emit(code.OpIndex)
+			}
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			if node.Operator != "=" {
+				c.emit(op)
+			}
+
+			c.emit(code.OpSetIndex)
+		default:
+			return c.newError(fmt.Sprintf("cannot assign to %s", node.Target))
+	}
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	loop := c.enterLoop()
+
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loop.continuePos)
+
+	c.changeOperand(exitPos, len(c.currentInstructions()))
+	c.leaveLoop()
+	return nil
+}
+
+// The iterator lives in a hidden variable, so break never leaves it on the stack
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	// Nested loops need their own iterators, loops after each other share one
+	depth := len(c.scopes[c.scopeIndex].loops)
+	iterator := c.symbolTable.Define(fmt.Sprintf("#iterator%d", depth))
+	c.storeSymbol(iterator)
+
+	loop := c.enterLoop()
+	c.loadSymbol(iterator)
+	exitPos := c.emit(code.OpIterNext, 9999)
+
+	variable := c.symbolTable.Define(node.Variable.Value)
+	c.storeSymbol(variable)
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loop.continuePos)
+
+	c.changeOperand(exitPos, len(c.currentInstructions()))
+	c.leaveLoop()
+	return nil
+}
+
+// Start a loop, continue jumps back to the current position
+func (c *Compiler) enterLoop() *Loop {
+	loop := &Loop{continuePos: len(c.currentInstructions())}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+// End the loop at the current position, where its breaks jump to
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	loop := loops[len(loops)-1]
+
+	for _, jumpPos := range loop.breakJumps {
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+func (c *Compiler) currentLoop() *Loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// Compile a block that leaves the value of its last statement on the stack
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	// A function that assigns to its own name changes the binding of its let,
+	// like in the evaluator, so it uses that binding instead of itself
+	assigned := node.Name != "" && assignsTo(node.Body, node.Name)
+	if assigned {
+		c.symbolTable.Define(node.Name)
+	}
+
+	c.enterScope()
+
+	if node.Name != "" && !assigned {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	// The value of the last expression is returned implicitly
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Name: node.Name,
+		Instructions: instructions,
+		NumLocals: numLocals,
+		NumParameters: len(node.Parameters),
+		Positions: positions,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return nil
+}
+
+// Whether node has an assignment to name, nested functions included
+func assignsTo(node ast.Node, name string) bool {
+	some := func(nodes ...ast.Node) bool {
+		for _, n := range nodes {
+			if n != nil && assignsTo(n, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch node := node.(type) {
+		case *ast.AssignExpression:
+			if target, ok := node.Target.(*ast.Identifier); ok && target.Value == name {
+				return true
+			}
+			return some(node.Target, node.Value)
+		case *ast.BlockStatement:
+			if node == nil {
+				return false
+			}
+			for _, s := range node.Statements {
+				if assignsTo(s, name) {
+					return true
+				}
+			}
+		case *ast.LetStatement:
+			return some(node.Value)
+		case *ast.ReturnStatement:
+			return some(node.ReturnValue)
+		case *ast.ExpressionStatement:
+			return some(node.Expression)
+		case *ast.ThrowStatement:
+			return some(node.Value)
+		case *ast.WhileStatement:
+			return some(node.Condition, node.Body)
+		case *ast.ForStatement:
+			return some(node.Iterable, node.Body)
+		case *ast.TryExpression:
+			return some(node.Block, node.Catch, node.Finally)
+		case *ast.PrefixExpression:
+			return some(node.Right)
+		case *ast.InfixExpression:
+			return some(node.Left, node.Right)
+		case *ast.IfExpression:
+			return some(node.Condition, node.Consequence, node.Alternative)
+		case *ast.FunctionLiteral:
+			return some(node.Body)
+		case *ast.CallExpression:
+			if some(node.Function) {
+				return true
+			}
+			for _, arg := range node.Arguments {
+				if assignsTo(arg, name) {
+					return true
+				}
+			}
+		case *ast.ArrayLiteral:
+			for _, e := range node.Elements {
+				if assignsTo(e, name) {
+					return true
+				}
+			}
+		case *ast.HashLiteral:
+			for _, key := range node.Keys {
+				if some(key, node.Pairs[key]) {
+					return true
+				}
+			}
+		case *ast.IndexExpression:
+			return some(node.Left, node.Index)
+		case *ast.SliceExpression:
+			return some(node.Left, node.Low, node.High)
+	}
+	return false
+}
+
+// Pop the top of the stack into a variable
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+		case GlobalScope:
+			c.emit(code.OpSetGlobal, s.Index)
+		case LocalScope:
+			c.emit(code.OpSetLocal, s.Index)
+		case FreeScope:
+			c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+		case GlobalScope:
+			c.emit(code.OpGetGlobal, s.Index)
+		case LocalScope:
+			c.emit(code.OpGetLocal, s.Index)
+		case FreeScope:
+			c.emit(code.OpGetFree, s.Index)
+		case FunctionScope:
+			c.emit(code.OpCurrentClosure)
+	}
+}
+
+// Push a variable for a closure. Locals and free variables are shared
+// through cells, so an assignment is seen by the closure and its creator
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+		case LocalScope:
+			c.emit(code.OpCaptureLocal, s.Index)
+		case FreeScope:
+			c.emit(code.OpCaptureFree, s.Index)
+		default:
+			c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) newError(msg string) error {
+	return &Error{Message: msg, Pos: c.pos}
+}
+
+func (c *Compiler) operandError(op code.Opcode, operand int) error {
+	switch op {
+		case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+			return c.newError(fmt.Sprintf("too many local variables: %d, the vm allows 256", operand + 1))
+		case code.OpCall:
+			return c.newError(fmt.Sprintf("too many arguments: %d, the vm allows 255", operand))
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			return c.newError(fmt.Sprintf("too many free variables: %d, the vm allows 256", operand))
+	}
+	def, _ := code.Lookup(byte(op))
+	return c.newError(fmt.Sprintf("program too large for the vm: operand %d of %s", operand, def.Name))
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// Append an instruction, returns its offset. An operand that does not fit
+// fails the compilation of the node being compiled
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	if o, overflows := code.Overflow(op, operands...); overflows && c.err == nil {
+		c.err = c.operandError(op, o)
+	}
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	if c.pos.IsValid() {
+		c.scopes[c.scopeIndex].positions[pos] = c.pos
+	}
+
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	delete(c.scopes[c.scopeIndex].positions, last.Position)
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	if o, overflows := code.Overflow(op, operand); overflows && c.err == nil {
+		c.err = c.operandError(op, o)
+	}
+	newInstruction := code.Make(op, operand)
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions: code.Instructions{},
+		positions: make(map[int]token.Position),
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex += 1
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex -= 1
+
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
+}
@@ -0,0 +1,109 @@
+package compiler
+
+import "sort"
+
+type SymbolScope string
+
+const (
+	GlobalScope		SymbolScope = "GLOBAL"
+	LocalScope		SymbolScope = "LOCAL"
+	FreeScope		SymbolScope = "FREE"
+	FunctionScope	SymbolScope = "FUNCTION"		// the function currently being defined
+)
+
+type Symbol struct {
+	Name	string
+	Scope	SymbolScope
+	Index	int
+}
+
+type SymbolTable struct {
+	Outer			*SymbolTable
+
+	store			map[string]Symbol
+	numDefinitions	int
+	FreeSymbols		[]Symbol		// symbols of outer scopes captured by this one
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Defining a name again in the same scope reuses its slot,
+// like the evaluator overwrites the binding in the same environment
+func (s *SymbolTable) Define(name string) Symbol {
+	if existing, ok := s.store[name]; ok {
+		if existing.Scope == GlobalScope || existing.Scope == LocalScope {
+			return existing
+		}
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions += 1
+	return symbol
+}
+
+// Let a function refer to itself without capturing anything
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// The symbols defined in this scope, not in the ones around it, sorted by name
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := []Symbol{}
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Name < symbols[j].Name
+	})
+	return symbols
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		// Globals are reachable from everywhere, anything else is captured
+		if obj.Scope == GlobalScope {
+			return obj, ok
+		}
+
+		free := s.defineFree(obj)
+		return free, true
+	}
+	return obj, ok
+}
@@ -0,0 +1,318 @@
+package monkey
+
+// Conversion between Go values and Monkey values
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+var objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// ToObject converts a Go value to a Monkey value:
+// nil is null, integers and floats are numbers, strings, bools,
+// slices and arrays are arrays, maps are hashes and functions are builtins.
+// Values that already are an object.Object are kept as they are
+func ToObject(value interface{}) (object.Object, error) {
+	if value == nil {
+		return evaluator.NULL, nil
+	}
+	if obj, ok := value.(object.Object); ok {
+		return obj, nil
+	}
+	return toObject(reflect.ValueOf(value))
+}
+
+func toObject(v reflect.Value) (object.Object, error) {
+	switch v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				return evaluator.TRUE, nil
+			}
+			return evaluator.FALSE, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return &object.Integer{Value: v.Int()}, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if v.Uint() > 1 << 63 - 1 {
+				return nil, fmt.Errorf("%d is too large for an integer", v.Uint())
+			}
+			return &object.Integer{Value: int64(v.Uint())}, nil
+		case reflect.Float32, reflect.Float64:
+			return &object.Float{Value: v.Float()}, nil
+		case reflect.String:
+			return &object.String{Value: v.String()}, nil
+		case reflect.Slice, reflect.Array:
+			elements := make([]object.Object, v.Len())
+			for i := range elements {
+				el, err := toObject(v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				elements[i] = el
+			}
+			return &object.Array{Elements: elements}, nil
+		case reflect.Map:
+			return mapToHash(v)
+		case reflect.Func:
+			return funcToBuiltin("", v), nil
+		case reflect.Interface, reflect.Ptr:
+			if v.IsNil() {
+				return evaluator.NULL, nil
+			}
+			if v.Type().Implements(objectType) {
+				return v.Interface().(object.Object), nil
+			}
+			return toObject(v.Elem())
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+}
+
+// Go maps have no order, the keys are added sorted by how they print
+func mapToHash(v reflect.Value) (object.Object, error) {
+	pairs := make([]object.HashPair, 0, v.Len())
+
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := toObject(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := object.AsHashable(key); !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		value, err := toObject(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, object.HashPair{Key: key, Value: value})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+	})
+	hash := object.NewHash()
+	for _, pair := range pairs {
+		hash.Set(pair.Key.(object.Hashable), pair.Value)
+	}
+	return hash, nil
+}
+
+// A builtin that converts its arguments to the parameter types of fn.
+// The number and types of the arguments are checked by the builtin
+// before fn is called. A last error result that is not nil becomes a Monkey error
+func funcToBuiltin(name string, fn reflect.Value) *object.Builtin {
+	t := fn.Type()
+
+	numIn := t.NumIn()
+	params := make([]object.BuiltinParam, numIn)
+	for i := range params {
+		paramType := t.In(i)
+		if t.IsVariadic() && i == numIn - 1 {
+			paramType = paramType.Elem()
+		}
+		params[i] = object.BuiltinParam{Types: objectTypes(paramType)}
+	}
+	if t.IsVariadic() {
+		numIn -= 1
+	}
+
+	return &object.Builtin{
+		Name: name,
+		Params: params,
+		Variadic: t.IsVariadic(),
+		Fn: func(args ...object.Object) object.Object {
+			in := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				var paramType reflect.Type
+				if t.IsVariadic() && i >= numIn {
+					paramType = t.In(numIn).Elem()
+				} else {
+					paramType = t.In(i)
+				}
+
+				value, err := toValue(arg, paramType)
+				if err != nil {
+					return &object.Error{Message: fmt.Sprintf("argument %d: %v", i + 1, err)}
+				}
+				in[i] = value
+			}
+
+			return fromResults(fn.Call(in))
+		},
+	}
+}
+
+// The Monkey types a Go parameter of type t accepts, nil for any type
+func objectTypes(t reflect.Type) []object.ObjectType {
+	switch t.Kind() {
+		case reflect.Bool:
+			return []object.ObjectType{object.BOOLEAN_OBJ}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return []object.ObjectType{object.INTEGER_OBJ}
+		case reflect.Float32, reflect.Float64:
+			return []object.ObjectType{object.FLOAT_OBJ, object.INTEGER_OBJ}
+		case reflect.String:
+			return []object.ObjectType{object.STRING_OBJ}
+		case reflect.Slice:
+			return []object.ObjectType{object.ARRAY_OBJ}
+		case reflect.Map:
+			return []object.ObjectType{object.HASH_OBJ}
+	}
+	return nil
+}
+
+func fromResults(out []reflect.Value) object.Object {
+	if n := len(out); n > 0 && out[n-1].Type() == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		out = out[:n-1]
+	}
+
+	if len(out) == 0 {
+		return evaluator.NULL
+	}
+
+	obj, err := toObject(out[0])
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return obj
+}
+
+// FromObject converts a Monkey value to a Go value: integers are int64,
+// floats float64, null is nil, arrays are []interface{} and hashes are
+// map[interface{}]interface{}. Functions, hash keys that are arrays
+// or hashes and arrays or hashes that contain themselves are returned as they are
+func FromObject(obj object.Object) interface{} {
+	return fromObject(obj, map[object.Object]bool{})
+}
+
+// Visiting holds the arrays and hashes being converted, to stop at cycles
+func fromObject(obj object.Object, visiting map[object.Object]bool) interface{} {
+	switch obj.(type) {
+		case *object.Array, *object.Hash:
+			if visiting[obj] {
+				return obj
+			}
+			visiting[obj] = true
+			defer delete(visiting, obj)
+	}
+
+	switch obj := obj.(type) {
+		case nil, *object.Null:
+			return nil
+		case *object.Integer:
+			return obj.Value
+		case *object.Float:
+			return obj.Value
+		case *object.Boolean:
+			return obj.Value
+		case *object.String:
+			return obj.Value
+		case *object.Array:
+			elements := make([]interface{}, len(obj.Elements))
+			for i, el := range obj.Elements {
+				elements[i] = fromObject(el, visiting)
+			}
+			return elements
+		case *object.Hash:
+			hash := make(map[interface{}]interface{}, obj.Len())
+			for _, pair := range obj.Pairs() {
+				// Arrays and hashes cannot be keys of a Go map, they stay Monkey values
+				key := fromObject(pair.Key, visiting)
+				if key != nil && !reflect.TypeOf(key).Comparable() {
+					key = pair.Key
+				}
+				hash[key] = fromObject(pair.Value, visiting)
+			}
+			return hash
+	}
+	return obj
+}
+
+// Convert a Monkey value to a Go value of type t
+func toValue(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	// interface{} gets the Go value, object.Object the Monkey value
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		value := FromObject(obj)
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(value), nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	switch t.Kind() {
+		case reflect.Bool:
+			if b, ok := obj.(*object.Boolean); ok {
+				return reflect.ValueOf(b.Value).Convert(t), nil
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if i, ok := obj.(*object.Integer); ok {
+				v := reflect.New(t).Elem()
+				if v.OverflowInt(i.Value) {
+					return v, fmt.Errorf("%d does not fit in %s", i.Value, t)
+				}
+				v.SetInt(i.Value)
+				return v, nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if i, ok := obj.(*object.Integer); ok {
+				v := reflect.New(t).Elem()
+				if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+					return v, fmt.Errorf("%d does not fit in %s", i.Value, t)
+				}
+				v.SetUint(uint64(i.Value))
+				return v, nil
+			}
+		case reflect.Float32, reflect.Float64:
+			switch n := obj.(type) {
+				case *object.Integer:
+					return reflect.ValueOf(float64(n.Value)).Convert(t), nil
+				case *object.Float:
+					return reflect.ValueOf(n.Value).Convert(t), nil
+			}
+		case reflect.String:
+			if s, ok := obj.(*object.String); ok {
+				return reflect.ValueOf(s.Value).Convert(t), nil
+			}
+		case reflect.Slice:
+			if a, ok := obj.(*object.Array); ok {
+				v := reflect.MakeSlice(t, len(a.Elements), len(a.Elements))
+				for i, el := range a.Elements {
+					elem, err := toValue(el, t.Elem())
+					if err != nil {
+						return v, err
+					}
+					v.Index(i).Set(elem)
+				}
+				return v, nil
+			}
+		case reflect.Map:
+			if h, ok := obj.(*object.Hash); ok {
+				v := reflect.MakeMapWithSize(t, h.Len())
+				for _, pair := range h.Pairs() {
+					key, err := toValue(pair.Key, t.Key())
+					if err != nil {
+						return v, err
+					}
+					value, err := toValue(pair.Value, t.Elem())
+					if err != nil {
+						return v, err
+					}
+					v.SetMapIndex(key, value)
+				}
+				return v, nil
+			}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
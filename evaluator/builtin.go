@@ -2,19 +2,34 @@ package evaluator
 
 import ( 
 	"fmt"
-	"../object"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"monkey/object"
 )
 
+// Types accepted by a parameter of a builtin
+func param(name string, types ...object.ObjectType) object.BuiltinParam {
+	return object.BuiltinParam{Name: name, Types: types}
+}
+
+// A parameter taking a function of the program or a builtin
+func fnParam(name string) object.BuiltinParam {
+	return param(name, object.FUNCTION_OBJ, object.BUILTIN_OBJ)
+}
+
+// The builtins every interpreter starts with. Types that are
+// checked by the function itself are left out of the parameters
 var builtins = map[string] *object.Builtin {
 	"len": &object.Builtin {
+		Params: []object.BuiltinParam{param("value")},
 		Fn : func (args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
 			switch arg := args[0].(type) {
 				case *object.String:
-					return &object.Integer{Value : int64(len(arg.Value))}
+					// Number of characters, len(bytes(s)) gives the number of bytes
+					return &object.Integer{Value : int64(utf8.RuneCountInString(arg.Value))}
 				case *object.Array:
 					return &object.Integer{Value: int64(len(arg.Elements))}
 				default:
@@ -22,30 +37,31 @@ var builtins = map[string] *object.Builtin {
 			}
 		},
 	},
-	"first": &object.Builtin {
+	"bytes": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ)},
 		Fn : func (args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			// The utf-8 encoding of the string
+			str := args[0].(*object.String).Value
+			elements := make([]object.Object, len(str))
+			for i := 0; i < len(str); i++ {
+				elements[i] = &object.Integer{Value: int64(str[i])}
 			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-			}
-
+			return &object.Array{Elements: elements}
+		},
+	},
+	"first": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ)},
+		Fn : func (args ...object.Object) object.Object {
 			array := args[0].(*object.Array)
+			if len(array.Elements) == 0 {
+				return newError("`first` called on an empty array")
+			}
 			return array.Elements[0]
 		},
 	},
 	"last" : &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ)},
 		Fn : func (args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-			}
-
 			array := args[0].(*object.Array)
 			num := len(array.Elements)
 			if num > 0 {
@@ -55,15 +71,8 @@ var builtins = map[string] *object.Builtin {
 		},	
 	},
 	"rest": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ)},
 		Fn : func (args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-			}
-			
 			array := args[0].(*object.Array)
 			newElements := []object.Object{}
 
@@ -79,15 +88,8 @@ var builtins = map[string] *object.Builtin {
 		},
 	},
 	"push" : &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), param("value")},
 		Fn : func(args...object.Object)object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
-			}
-
 			array := args[0].(*object.Array)
 			newElements := []object.Object{}
 
@@ -99,7 +101,58 @@ var builtins = map[string] *object.Builtin {
 			return &object.Array{Elements:newElements}
 		},
 	},
+	"int" : &object.Builtin {
+		Params: []object.BuiltinParam{param("value")},
+		Fn : func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+				case *object.Integer:
+					return arg
+				case *object.Float:
+					// Truncates towards zero
+					if math.IsNaN(arg.Value) || arg.Value >= 1<<63 || arg.Value < -1<<63 {
+						return newError("could not convert %s to integer", arg.Inspect())
+					}
+					return &object.Integer{Value: int64(arg.Value)}
+				case *object.String:
+					value, err := strconv.ParseInt(arg.Value, 0, 64)
+					if err != nil {
+						return newError("could not parse %q as integer", arg.Value)
+					}
+					return &object.Integer{Value: value}
+				default:
+					return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	"float" : &object.Builtin {
+		Params: []object.BuiltinParam{param("value")},
+		Fn : func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+				case *object.Integer:
+					return &object.Float{Value: float64(arg.Value)}
+				case *object.Float:
+					return arg
+				case *object.String:
+					value, err := strconv.ParseFloat(arg.Value, 64)
+					if err != nil {
+						return newError("could not parse %q as float", arg.Value)
+					}
+					return &object.Float{Value: value}
+				default:
+					return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	"error" : &object.Builtin {
+		Params: []object.BuiltinParam{param("message", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			message := args[0].(*object.String)
+			return newErrorHash(message.Value, []object.Object{})
+		},
+	},
 	"puts" : &object.Builtin {
+		Params: []object.BuiltinParam{param("values")},
+		Variadic: true,
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
 				fmt.Println(arg.Inspect())
@@ -107,5 +160,540 @@ var builtins = map[string] *object.Builtin {
 			return NULL
 		},
 	},
+	"map": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn")},
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			elements := args[0].(*object.Array).Elements
+			mapped := make([]object.Object, len(elements))
+			for i, e := range elements {
+				result := apply(args[1], e)
+				if isError(result) {
+					return result
+				}
+				mapped[i] = result
+			}
+			return &object.Array{Elements: mapped}
+		},
+	},
+	"filter": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn")},
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			kept := []object.Object{}
+			for _, e := range args[0].(*object.Array).Elements {
+				result := apply(args[1], e)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					kept = append(kept, e)
+				}
+			}
+			return &object.Array{Elements: kept}
+		},
+	},
+	"reduce": &object.Builtin {
+		// Without an initial value the first element is the start
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn"), param("initial")},
+		Variadic: true,
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			if len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want at most 3", len(args))
+			}
+
+			elements := args[0].(*object.Array).Elements
+			var acc object.Object
+			if len(args) == 3 {
+				acc = args[2]
+			} else if len(elements) > 0 {
+				acc, elements = elements[0], elements[1:]
+			} else {
+				return newError("`reduce` called on an empty array without an initial value")
+			}
+
+			for _, e := range elements {
+				acc = apply(args[1], acc, e)
+				if isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		},
+	},
+	"each": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn")},
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			for _, e := range args[0].(*object.Array).Elements {
+				if result := apply(args[1], e); isError(result) {
+					return result
+				}
+			}
+			return NULL
+		},
+	},
+	"find": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn")},
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			for _, e := range args[0].(*object.Array).Elements {
+				result := apply(args[1], e)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return e
+				}
+			}
+			return NULL
+		},
+	},
+	"any": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn")},
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			for _, e := range args[0].(*object.Array).Elements {
+				result := apply(args[1], e)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return TRUE
+				}
+			}
+			return FALSE
+		},
+	},
+	"all": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("fn")},
+		Apply: func(apply object.Applier, args ...object.Object) object.Object {
+			for _, e := range args[0].(*object.Array).Elements {
+				result := apply(args[1], e)
+				if isError(result) {
+					return result
+				}
+				if !isTruthy(result) {
+					return FALSE
+				}
+			}
+			return TRUE
+		},
+	},
+	"sort": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), fnParam("comparator")},
+		Variadic: true,
+		Apply: builtinSort,
+	},
+	"zip": &object.Builtin {
+		// As long as the shortest array
+		Params: []object.BuiltinParam{param("arrays", object.ARRAY_OBJ)},
+		Variadic: true,
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return &object.Array{Elements: []object.Object{}}
+			}
+
+			n := len(args[0].(*object.Array).Elements)
+			for _, arg := range args[1:] {
+				if l := len(arg.(*object.Array).Elements); l < n {
+					n = l
+				}
+			}
+
+			zipped := make([]object.Object, n)
+			for i := 0; i < n; i++ {
+				tuple := make([]object.Object, len(args))
+				for j, arg := range args {
+					tuple[j] = arg.(*object.Array).Elements[i]
+				}
+				zipped[i] = &object.Array{Elements: tuple}
+			}
+			return &object.Array{Elements: zipped}
+		},
+	},
+	"range": &object.Builtin {
+		// range(stop), range(start, stop) or range(start, stop, step), stop is left out
+		Params: []object.BuiltinParam{param("bounds", object.INTEGER_OBJ)},
+		Variadic: true,
+		Fn: builtinRange,
+	},
+	"flatten": &object.Builtin {
+		// One level, arrays in the nested arrays are kept
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			flat := []object.Object{}
+			for _, e := range args[0].(*object.Array).Elements {
+				if nested, ok := e.(*object.Array); ok {
+					flat = append(flat, nested.Elements...)
+				} else {
+					flat = append(flat, e)
+				}
+			}
+			return &object.Array{Elements: flat}
+		},
+	},
+	"uniq": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ)},
+		Fn: builtinUniq,
+	},
+	"reverse": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			elements := args[0].(*object.Array).Elements
+			reversed := make([]object.Object, len(elements))
+			for i, e := range elements {
+				reversed[len(elements)-1-i] = e
+			}
+			return &object.Array{Elements: reversed}
+		},
+	},
+	"slice": &object.Builtin {
+		// Negative indices count from the end, the end is left out
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), param("start", object.INTEGER_OBJ),
+			param("end", object.INTEGER_OBJ)},
+		Variadic: true,
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want at most 3", len(args))
+			}
+
+			high := object.Object(NULL)
+			if len(args) == 3 {
+				high = args[2]
+			}
+			return evalSliceExpression(args[0], args[1], high)
+		},
+	},
+	"concat": &object.Builtin {
+		Params: []object.BuiltinParam{param("arrays", object.ARRAY_OBJ)},
+		Variadic: true,
+		Fn: func(args ...object.Object) object.Object {
+			joined := []object.Object{}
+			for _, arg := range args {
+				joined = append(joined, arg.(*object.Array).Elements...)
+			}
+			return &object.Array{Elements: joined}
+		},
+	},
+	"strings.split": &object.Builtin {
+		// Without a separator the string is split around whitespace,
+		// an empty separator splits it into characters
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("sep", object.STRING_OBJ)},
+		Variadic: true,
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) > 2 {
+				return newError("wrong number of arguments. got=%d, want at most 2", len(args))
+			}
+
+			s := args[0].(*object.String).Value
+			var parts []string
+			if len(args) == 1 {
+				parts = strings.Fields(s)
+			} else {
+				parts = strings.Split(s, args[1].(*object.String).Value)
+			}
+			return stringArray(parts)
+		},
+	},
+	"strings.join": &object.Builtin {
+		Params: []object.BuiltinParam{param("array", object.ARRAY_OBJ), param("sep", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			elements := args[0].(*object.Array).Elements
+			parts := make([]string, len(elements))
+			for i, e := range elements {
+				str, ok := e.(*object.String)
+				if !ok {
+					return newError("element %d of the array to `strings.join` must be STRING, got %s", i, e.Type())
+				}
+				parts[i] = str.Value
+			}
+			return &object.String{Value: strings.Join(parts, args[1].(*object.String).Value)}
+		},
+	},
+	"strings.trim": &object.Builtin {
+		// Whitespace at both ends
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.TrimSpace(args[0].(*object.String).Value)}
+		},
+	},
+	"strings.upper": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.ToUpper(args[0].(*object.String).Value)}
+		},
+	},
+	"strings.lower": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.ToLower(args[0].(*object.String).Value)}
+		},
+	},
+	"strings.contains": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("substr", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			return nativeBoolToBooleanObject(strings.Contains(args[0].(*object.String).Value, args[1].(*object.String).Value))
+		},
+	},
+	"strings.starts_with": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("prefix", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			return nativeBoolToBooleanObject(strings.HasPrefix(args[0].(*object.String).Value, args[1].(*object.String).Value))
+		},
+	},
+	"strings.ends_with": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("suffix", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			return nativeBoolToBooleanObject(strings.HasSuffix(args[0].(*object.String).Value, args[1].(*object.String).Value))
+		},
+	},
+	"strings.index_of": &object.Builtin {
+		// The index in characters, like s[i], or -1
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("substr", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			s := args[0].(*object.String).Value
+			i := strings.Index(s, args[1].(*object.String).Value)
+			if i > 0 {
+				i = utf8.RuneCountInString(s[:i])
+			}
+			return &object.Integer{Value: int64(i)}
+		},
+	},
+	"strings.replace": &object.Builtin {
+		// Every occurrence
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("old", object.STRING_OBJ),
+			param("new", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			s := args[0].(*object.String).Value
+			return &object.String{Value: strings.ReplaceAll(s, args[1].(*object.String).Value, args[2].(*object.String).Value)}
+		},
+	},
+	"strings.repeat": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("count", object.INTEGER_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			s := args[0].(*object.String).Value
+			count := args[1].(*object.Integer).Value
+			if count < 0 {
+				return newError("count of `strings.repeat` must not be negative, got %d", count)
+			}
+			if count > 0 && int64(len(s)) > int64(maxInt) / count {
+				return newError("`strings.repeat` result too long")
+			}
+			return &object.String{Value: strings.Repeat(s, int(count))}
+		},
+		Size: func(args ...object.Object) int64 {
+			n := int64(len(args[0].(*object.String).Value))
+			count := args[1].(*object.Integer).Value
+			if count <= 0 {
+				return 0
+			}
+			if n > math.MaxInt64 / count {
+				return math.MaxInt64
+			}
+			return n * count
+		},
+	},
+	"strings.substring": &object.Builtin {
+		// Like s[start:end]
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ), param("start", object.INTEGER_OBJ),
+			param("end", object.INTEGER_OBJ)},
+		Variadic: true,
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want at most 3", len(args))
+			}
+
+			high := object.Object(NULL)
+			if len(args) == 3 {
+				high = args[2]
+			}
+			return evalSliceExpression(args[0], args[1], high)
+		},
+	},
+	"strings.chars": &object.Builtin {
+		Params: []object.BuiltinParam{param("s", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			s := args[0].(*object.String).Value
+			chars := make([]string, 0, len(s))
+			for _, ch := range s {
+				chars = append(chars, string(ch))
+			}
+			return stringArray(chars)
+		},
+	},
+	"strings.ord": &object.Builtin {
+		// The code point of a character
+		Params: []object.BuiltinParam{param("char", object.STRING_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			s := args[0].(*object.String).Value
+			if utf8.RuneCountInString(s) != 1 {
+				return newError("argument to `strings.ord` must be one character, got %d", utf8.RuneCountInString(s))
+			}
+			ch, _ := utf8.DecodeRuneInString(s)
+			return &object.Integer{Value: int64(ch)}
+		},
+	},
+	"strings.chr": &object.Builtin {
+		Params: []object.BuiltinParam{param("code", object.INTEGER_OBJ)},
+		Fn: func(args ...object.Object) object.Object {
+			code := args[0].(*object.Integer).Value
+			if code < 0 || code > utf8.MaxRune || !utf8.ValidRune(rune(code)) {
+				return newError("%d is not a unicode code point", code)
+			}
+			return &object.String{Value: string(rune(code))}
+		},
+	},
+}
+
+// Longest string strings.repeat can make, its length is an int
+const maxInt = int(^uint(0) >> 1)
+
+func stringArray(strs []string) *object.Array {
+	elements := make([]object.Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &object.String{Value: s}
+	}
+	return &object.Array{Elements: elements}
 }
 
+// A sorted copy of the array. The comparator gets two elements and returns
+// a negative number if the first one goes first, 0 if they are equal
+func builtinSort(apply object.Applier, args ...object.Object) object.Object {
+	if len(args) > 2 {
+		return newError("wrong number of arguments. got=%d, want at most 2", len(args))
+	}
+
+	sorted := make([]object.Object, len(args[0].(*object.Array).Elements))
+	copy(sorted, args[0].(*object.Array).Elements)
+
+	// The first error stops the comparisons
+	var errObj object.Object
+	less := func(a, b object.Object) bool {
+		if len(args) == 1 {
+			c, ok := object.Compare(a, b)
+			if !ok {
+				errObj = newError("`sort` cannot compare %s and %s", a.Type(), b.Type())
+			}
+			return c < 0
+		}
+
+		result := apply(args[1], a, b)
+		switch result := result.(type) {
+			case *object.Integer:
+				return result.Value < 0
+			case *object.Float:
+				return result.Value < 0
+			case *object.Error:
+				errObj = result
+			default:
+				errObj = newError("comparator of `sort` must return INTEGER or FLOAT, got %s", result.Type())
+		}
+		return false
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return errObj == nil && less(sorted[i], sorted[j])
+	})
+	if errObj != nil {
+		return errObj
+	}
+	return &object.Array{Elements: sorted}
+}
+
+func builtinRange(args ...object.Object) object.Object {
+	if len(args) == 0 || len(args) > 3 {
+		return newError("wrong number of arguments. got=%d, want 1 to 3", len(args))
+	}
+
+	bounds := []int64{0, 0, 1}
+	for i, arg := range args {
+		bounds[i] = arg.(*object.Integer).Value
+	}
+	if len(args) == 1 {
+		bounds[0], bounds[1] = 0, bounds[0]
+	}
+
+	start, stop, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return newError("step of `range` must not be 0")
+	}
+
+	// Counted in uint64, the distance between two int64 always fits
+	var distance, stride uint64
+	if step > 0 && start < stop {
+		distance, stride = uint64(stop) - uint64(start), uint64(step)
+	} else if step < 0 && start > stop {
+		distance, stride = uint64(start) - uint64(stop), uint64(-(step + 1)) + 1
+	}
+	n := uint64(0)
+	if distance > 0 {
+		n = (distance - 1) / stride + 1
+	}
+	if n > maxArrayLength {
+		return newError("`range` result too long")
+	}
+
+	elements := make([]object.Object, n)
+	for i := range elements {
+		elements[i] = &object.Integer{Value: start}
+		start += step
+	}
+	return &object.Array{Elements: elements}
+}
+
+// Most elements range makes, larger arrays would exhaust the memory
+// before the allocation limit sees them
+const maxArrayLength = 1 << 24
+
+// The elements in the order they first appear, compared like ==
+func builtinUniq(args ...object.Object) object.Object {
+	seen := object.NewHash()
+	var others []object.Object		// elements that cannot be hash keys, e.g. functions
+	kept := []object.Object{}
+
+	for _, e := range args[0].(*object.Array).Elements {
+		if key, ok := object.AsHashable(e); ok {
+			if _, found := seen.Get(key); found {
+				continue
+			}
+			seen.Set(key, TRUE)
+		} else {
+			found := false
+			for _, other := range others {
+				if object.Equal(e, other) {
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+			others = append(others, e)
+		}
+		kept = append(kept, e)
+	}
+	return &object.Array{Elements: kept}
+}
+
+// Used by environments without a registry of their own
+var defaultRegistry *object.Registry
+
+func init() {
+	for name, builtin := range builtins {
+		builtin.Name = name
+	}
+	defaultRegistry = NewRegistry()
+}
+
+// A registry with the builtins every interpreter starts with,
+// hosts can add their own functions to it
+func NewRegistry() *object.Registry {
+	// In the order of their names, so namespaces always list them the same way
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := object.NewRegistry()
+	for _, name := range names {
+		r.Register(builtins[name])
+	}
+	return r
+}
@@ -1,18 +1,46 @@
 package evaluator
 
 import(
+	"context"
 	"fmt"
-	"../ast"
-	"../object"
+	"math"
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
 )
 
 var (
 	TRUE = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL = &object.Null{}
+	BREAK = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval (node ast.Node, env *object.Environment) object.Object {
+	var result object.Object
+	if errObj := env.Context().Step(); errObj != nil {
+		result = errObj
+	} else {
+		result = eval(node, env)
+	}
+
+	// The innermost node that produced an error is where it happened
+	if errObj, ok := result.(*object.Error); ok && !errObj.Pos.IsValid() {
+		errObj.Pos = node.Pos()
+	}
+	return result
+}
+
+// Like Eval, but stops with an error when ctx is canceled or one of its limits is reached
+func EvalWithContext(ctx *object.EvalContext, node ast.Node, env *object.Environment) object.Object {
+	previous := env.SetContext(ctx)
+	defer env.SetContext(previous)
+
+	return Eval(node, env)
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 		case *ast.Program:
 			return evalProgram(node, env)
@@ -21,6 +49,8 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 		// Expression
 		case *ast.IntegerLiteral:
 			return &object.Integer{Value:node.Value}
+		case *ast.FloatLiteral:
+			return &object.Float{Value:node.Value}
 		case *ast.Boolean:
 			return nativeBoolToBooleanObject(node.Value)
 		case *ast.PrefixExpression:
@@ -30,6 +60,10 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 			}
 			return evalPrefixExpression(node.Operator, right)
 		case *ast.InfixExpression:
+			if node.Operator == "&&" || node.Operator == "||" {
+				return evalLogicalExpression(node, env)
+			}
+
 			left := Eval(node.Left, env)
 			if isError(left) {
 				return left
@@ -38,7 +72,7 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 			if isError(right) {
 				return right
 			}
-			return evalInfixExpression(node.Operator,left, right)
+			return allocate(env, evalInfixExpression(node.Operator,left, right))
 		case *ast.IfExpression:
 			return evalIfExpression(node, env)
 		case *ast.BlockStatement:
@@ -49,6 +83,22 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 				return val
 			}
 			return &object.ReturnValue{Value: val}
+		case *ast.WhileStatement:
+			return evalWhileStatement(node, env)
+		case *ast.ForStatement:
+			return evalForStatement(node, env)
+		case *ast.BreakStatement:
+			return BREAK
+		case *ast.ContinueStatement:
+			return CONTINUE
+		case *ast.ThrowStatement:
+			val := Eval(node.Value, env)
+			if isError(val) {
+				return val
+			}
+			return thrownError(val)
+		case *ast.TryExpression:
+			return evalTryExpression(node, env)
 		case *ast.LetStatement: 
 			val := Eval(node.Value, env)
 			if isError(val) {
@@ -62,15 +112,19 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 			if len(elements) == 1 && isError(elements[0]) {
 				return elements[0] 
 			}
-			return &object.Array{Elements:elements}
+			return allocate(env, &object.Array{Elements:elements})
 		case *ast.HashLiteral:
-			return evalHashLiteral(node, env)
+			hash := evalHashLiteral(node, env)
+			if isError(hash) {
+				return hash
+			}
+			return allocate(env, hash)
 		case *ast.StringLiteral:
 			return &object.String{Value:node.Value}
 		case *ast.FunctionLiteral:
 			params  := node.Parameters
 			body 	:= node.Body
-			return &object.Function{Parameters:params, Body:body, Env:env}
+			return &object.Function{Name:node.Name, Parameters:params, Body:body, Env:env}
 		case *ast.CallExpression:
 			function := Eval(node.Function, env)
 			if isError(function) {
@@ -81,7 +135,14 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 			if len(args) == 1 && isError(args[0]) {
 				return args[0]
 			}
-			return applyFunction(function, args)
+
+			result := applyFunction(env.Context(), function, args, node.Pos())
+			if _, ok := function.(*object.Builtin); ok {
+				return allocate(env, result)
+			}
+			return result
+		case *ast.AssignExpression:
+			return evalAssignExpression(node, env)
 		case *ast.IndexExpression:
 			left := Eval(node.Left, env)
 			if isError(left) {
@@ -93,12 +154,41 @@ func Eval (node ast.Node, env *object.Environment) object.Object {
 				return index
 			}
 			return evalIndexExpression(left, index)
+		case *ast.SliceExpression:
+			left := Eval(node.Left, env)
+			if isError(left) {
+				return left
+			}
+
+			// A bound that is left out is null
+			bounds := []object.Object{NULL, NULL}
+			for i, bound := range []ast.Expression{node.Low, node.High} {
+				if bound == nil {
+					continue
+				}
+				bounds[i] = Eval(bound, env)
+				if isError(bounds[i]) {
+					return bounds[i]
+				}
+			}
+			return allocate(env, evalSliceExpression(left, bounds[0], bounds[1]))
 	}
 	return NULL
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+func evalProgram(program *ast.Program, env *object.Environment) (result object.Object) {
+	// Without limits, deep recursion still has to fail before the Go stack does
+	if env.Context() == nil {
+		env.SetContext(object.NewEvalContext(context.Background(), object.Limits{}))
+		defer env.SetContext(nil)
+	}
+
+	// A bug in the interpreter should not take down a long running repl
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
 
 	for _, stmt := range program.Statements {
 		result = Eval(stmt, env)
@@ -109,13 +199,6 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			case *object.Error:
 				return result
 		}
-
-		// It finally get unwrap here
-		if returnValue, ok := result.(*object.ReturnValue); ok {
-			return returnValue.Value
-		}
-
-
 	}
 	return result
 }
@@ -145,12 +228,14 @@ func evalBangOperatorExpression(Obj object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+		case *object.Integer:
+			return &object.Integer{Value:-right.Value}
+		case *object.Float:
+			return &object.Float{Value:-right.Value}
+		default:
+			return newError("unknown operator: -%s", right.Type())
 	}
-
-	result := right.(*object.Integer)
-	return &object.Integer{Value:-result.Value}
 }
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object{
@@ -159,13 +244,36 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 		// since we always allocate new instance for integers
 		case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 			return evalIntegerInfixExpression(operator, left, right)
+		// An integer and a float are compared exactly, see object.Compare
+		case isNumber(left) && isNumber(right) && left.Type() != right.Type() &&
+			(isComparison(operator) || operator == "==" || operator == "!="):
+			c, ok := object.Compare(left, right)
+			if operator == "!=" {
+				return nativeBoolToBooleanObject(!ok || c != 0)
+			}
+			if !ok {
+				// NaN is not equal to or ordered with anything
+				return FALSE
+			}
+			if operator == "==" {
+				return nativeBoolToBooleanObject(c == 0)
+			}
+			return compareResult(operator, c)
+		// Mixed integers and floats are computed as floats
+		case isNumber(left) && isNumber(right):
+			return evalFloatInfixExpression(operator, left, right)
 		case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 			return evalStringInfixExpression(operator, left, right)
-		// We can write so since we only have two boolean object here
+		// Arrays and hashes are equal by their contents, see object.Equal
 		case operator == "==":
-			return nativeBoolToBooleanObject(left == right)
+			return nativeBoolToBooleanObject(object.Equal(left, right))
 		case operator == "!=":
-			return nativeBoolToBooleanObject(left != right)
+			return nativeBoolToBooleanObject(!object.Equal(left, right))
+		case isComparison(operator) && left.Type() == right.Type():
+			if c, ok := object.Compare(left, right); ok {
+				return compareResult(operator, c)
+			}
+			return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 		case left.Type() != right.Type():
 			return newError("type mismatch: %s %s %s",
 				left.Type(), operator, right.Type())
@@ -174,6 +282,24 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
+func isComparison(operator string) bool {
+	return operator == "<" || operator == ">" || operator == "<=" || operator == ">="
+}
+
+// The result of the comparison for the order c given by object.Compare
+func compareResult(operator string, c int) object.Object {
+	switch operator {
+		case "<":
+			return nativeBoolToBooleanObject(c < 0)
+		case ">":
+			return nativeBoolToBooleanObject(c > 0)
+		case "<=":
+			return nativeBoolToBooleanObject(c <= 0)
+		default:
+			return nativeBoolToBooleanObject(c >= 0)
+	}
+}
+
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object{
 	leftValue := left.(*object.Integer).Value
 	rightValue := right.(*object.Integer).Value
@@ -186,11 +312,29 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		case "*":
 			return &object.Integer{Value:leftValue * rightValue}
 		case "/":
+			if rightValue == 0 {
+				return newError("division by zero")
+			}
 			return &object.Integer{Value:leftValue / rightValue}
+		case "%":
+			if rightValue == 0 {
+				return newError("division by zero")
+			}
+			return &object.Integer{Value:leftValue % rightValue}
+		case "**":
+			// A negative exponent gives a fraction
+			if rightValue < 0 {
+				return &object.Float{Value:math.Pow(float64(leftValue), float64(rightValue))}
+			}
+			return &object.Integer{Value:integerPower(leftValue, rightValue)}
 		case ">":
 			return nativeBoolToBooleanObject(leftValue > rightValue)
 		case "<":
 			return nativeBoolToBooleanObject(leftValue < rightValue)
+		case ">=":
+			return nativeBoolToBooleanObject(leftValue >= rightValue)
+		case "<=":
+			return nativeBoolToBooleanObject(leftValue <= rightValue)
 		case "==":
 			return nativeBoolToBooleanObject(leftValue == rightValue)
 		case "!=":
@@ -201,6 +345,66 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object{
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch operator {
+		case "+":
+			return &object.Float{Value:leftValue + rightValue}
+		case "-":
+			return &object.Float{Value:leftValue - rightValue}
+		case "*":
+			return &object.Float{Value:leftValue * rightValue}
+		case "/":
+			return &object.Float{Value:leftValue / rightValue}
+		case "%":
+			return &object.Float{Value:math.Mod(leftValue, rightValue)}
+		case "**":
+			return &object.Float{Value:math.Pow(leftValue, rightValue)}
+		case ">":
+			return nativeBoolToBooleanObject(leftValue > rightValue)
+		case "<":
+			return nativeBoolToBooleanObject(leftValue < rightValue)
+		case ">=":
+			return nativeBoolToBooleanObject(leftValue >= rightValue)
+		case "<=":
+			return nativeBoolToBooleanObject(leftValue <= rightValue)
+		case "==":
+			return nativeBoolToBooleanObject(leftValue == rightValue)
+		case "!=":
+			return nativeBoolToBooleanObject(leftValue != rightValue)
+		default:
+			return newError("unknown operator: %s %s %s",
+               left.Type(), operator, right.Type())
+	}
+}
+
+// Exponentiation by squaring, overflows wrap around like the other operators
+func integerPower(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp & 1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// Only call it with numbers
+func toFloat(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
 // Avoid Creating multiple TRUE or FALSE boolean objects
 func nativeBoolToBooleanObject(Value bool) object.Object{
 	if Value {
@@ -223,6 +427,27 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+// The right side is only evaluated when the left one does not decide the result
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func isTruthy(obj object.Object) bool{
 	switch obj {
 		case FALSE:
@@ -245,14 +470,177 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
 	}
+
+	// An empty block, or one ending with a statement that has no value
+	if result == nil {
+		return NULL
+	}
 	return result
 }
 
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := Eval(ws.Body, env)
+		if stop, value := loopControl(result); stop {
+			return value
+		}
+	}
+}
+
+// The loop variable is bound in the current environment,
+// the same way a let in the loop body would be
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	items, err := iterableItems(iterable)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		env.Set(fs.Variable.Value, item)
+
+		result := Eval(fs.Body, env)
+		if stop, value := loopControl(result); stop {
+			return value
+		}
+	}
+	return NULL
+}
+
+// An error in the block goes to the catch block, the finally block always runs.
+// A finally block that returns, breaks or fails wins over the result of the others
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+	if aborts(result) {
+		return result
+	}
+
+	if errObj, ok := result.(*object.Error); ok && te.Catch != nil {
+		env.Set(te.CatchParam.Value, errorValue(errObj))
+		result = Eval(te.Catch, env)
+		if aborts(result) {
+			return result
+		}
+	}
+
+	if te.Finally != nil {
+		finally := Eval(te.Finally, env)
+		if finally != nil {
+			switch finally.Type() {
+				case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+					return finally
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// The value a catch block gets for an error, a hash with its message
+// and the calls it came through, innermost first
+func errorValue(errObj *object.Error) object.Object {
+	stack := []object.Object{}
+	for _, frame := range errObj.Stack {
+		call := fmt.Sprintf("%s (%s)", frame.Function, frame.Pos)
+		stack = append(stack, &object.String{Value: call})
+	}
+
+	return newErrorHash(errObj.Message, stack)
+}
+
+func newErrorHash(message string, stack []object.Object) *object.Hash {
+	hash := object.NewHash()
+	hash.Set(&object.String{Value: "message"}, &object.String{Value: message})
+	hash.Set(&object.String{Value: "stack"}, &object.Array{Elements: stack})
+	return hash
+}
+
+// The error raised by throw. Strings are the message, hashes like the ones
+// error() and catch give have a message field, other values are printed
+func thrownError(val object.Object) *object.Error {
+	message := val.Inspect()
+
+	if hash, ok := val.(*object.Hash); ok {
+		if value, ok := hash.Get(&object.String{Value: "message"}); ok {
+			message = value.Inspect()
+		}
+	}
+	return &object.Error{Message: message}
+}
+
+// Decide what a loop does after its body returned result.
+// Returns true and the value of the loop if the loop has to stop
+func loopControl(result object.Object) (bool, object.Object) {
+	if result == nil {
+		return false, nil
+	}
+
+	switch result.Type() {
+		case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+			return true, result
+		case object.BREAK_OBJ:
+			return true, NULL
+	}
+	return false, nil
+}
+
+// Values a for loop goes through: array elements, string characters or hash keys
+func iterableItems(obj object.Object) ([]object.Object, object.Object) {
+	switch obj := obj.(type) {
+		case *object.Array:
+			return obj.Elements, nil
+		case *object.String:
+			items := []object.Object{}
+			for _, ch := range obj.Value {
+				items = append(items, &object.String{Value: string(ch)})
+			}
+			return items, nil
+		case *object.Hash:
+			items := []object.Object{}
+			for _, pair := range obj.Pairs() {
+				items = append(items, pair.Key)
+			}
+			return items, nil
+		default:
+			return nil, newError("cannot iterate over %s", obj.Type())
+	}
+}
+
+// Errors of limits and cancellation go straight to the top
+func aborts(obj object.Object) bool {
+	errObj, ok := obj.(*object.Error)
+	return ok && errObj.Aborts()
+}
+
+// Count a new value against the allocation limit of the evaluation
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	if errObj := env.Context().Allocate(obj); errObj != nil {
+		return errObj
+	}
+	return obj
+}
+
 func newError(format string, a ...interface{}) object.Object {
 	return &object.Error{Message:fmt.Sprintf(format, a...)}
 }
@@ -269,7 +657,11 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	registry := env.Builtins()
+	if registry == nil {
+		registry = defaultRegistry
+	}
+	if builtin, ok := registry.Lookup(node.Value); ok {
 		return builtin
 	}
 
@@ -290,19 +682,48 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, params []object.Object) object.Object {
+// Pos is where the function is called, for the stack trace of errors coming out of it.
+// Builtins allocate in ctx, functions in the context of their environment
+func applyFunction(ctx *object.EvalContext, fn object.Object, params []object.Object, pos token.Position) object.Object {
 	switch fn := fn.(type) {
 		case *object.Function:
+			// Extra arguments are ignored
+			if len(params) < len(fn.Parameters) {
+				return newError("wrong number of arguments. got=%d, want=%d",
+					len(params), len(fn.Parameters))
+			}
+
+			fnCtx := fn.Env.Context()
+			if errObj := fnCtx.Call(); errObj != nil {
+				return errObj
+			}
+			defer fnCtx.Return()
+
 			extendedEnv := extendedFunctionEnv(fn, params)
 			evaluated := Eval(fn.Body, extendedEnv)
+			if errObj, ok := evaluated.(*object.Error); ok {
+				addStackFrame(errObj, fn, pos)
+			}
 			return unwrapReturnValue(evaluated)
 		case *object.Builtin:
-			return fn.Fn(params...)
+			// Functions called by the builtin, e.g. the one given to map, are called from pos too
+			return fn.CallWith(ctx, func(f object.Object, args ...object.Object) object.Object {
+				return applyFunction(ctx, f, args, pos)
+			}, params...)
 		default:
 			return newError("not a function: %s", fn.Type())
 	}
 }
 
+// Record the call of fn on the stack of an error coming out of it
+func addStackFrame(errObj *object.Error, fn *object.Function, pos token.Position) {
+	name := fn.Name
+	if name == "" {
+		name = object.ANONYMOUS_FUNCTION
+	}
+	errObj.Stack = append(errObj.Stack, object.StackFrame{Function: name, Pos: pos})
+}
+
 func extendedFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
 
@@ -320,19 +741,36 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func evalStringInfixExpression(operator string, left,right object.Object) object.Object{
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
-	return &object.String{Value:leftValue + rightValue}
+
+	// Strings are compared byte-wise
+	switch operator {
+		case "+":
+			return &object.String{Value:leftValue + rightValue}
+		case "==":
+			return nativeBoolToBooleanObject(leftValue == rightValue)
+		case "!=":
+			return nativeBoolToBooleanObject(leftValue != rightValue)
+		case "<":
+			return nativeBoolToBooleanObject(leftValue < rightValue)
+		case ">":
+			return nativeBoolToBooleanObject(leftValue > rightValue)
+		case "<=":
+			return nativeBoolToBooleanObject(leftValue <= rightValue)
+		case ">=":
+			return nativeBoolToBooleanObject(leftValue >= rightValue)
+		default:
+			return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 		case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 			return evalArrayIndexExpression(left, index)
+		case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+			return evalStringIndexExpression(left, index)
 		case left.Type() == object.HASH_OBJ:
 			return evalHashIndexExpression(left, index)
 		default:
@@ -340,6 +778,75 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// Strings are indexed by characters, not bytes
+func evalStringIndexExpression(left, index object.Object) object.Object {
+	idx := index.(*object.Integer).Value
+	str := left.(*object.String).Value
+
+	if idx < 0 {
+		return NULL
+	}
+
+	i := int64(0)
+	for _, ch := range str {
+		if i == idx {
+			return &object.String{Value: string(ch)}
+		}
+		i += 1
+	}
+	return NULL
+}
+
+// Arrays and strings, strings by characters. Negative bounds count from the end
+// and the bounds are kept inside the elements, null bounds are the ends
+func evalSliceExpression(left, low, high object.Object) object.Object {
+	for _, bound := range []object.Object{low, high} {
+		if bound != NULL && bound.Type() != object.INTEGER_OBJ {
+			return newError("slice bounds must be INTEGER, got %s", bound.Type())
+		}
+	}
+
+	switch left := left.(type) {
+		case *object.Array:
+			start, end := sliceBounds(len(left.Elements), low, high)
+			sliced := make([]object.Object, end-start)
+			copy(sliced, left.Elements[start:end])
+			return &object.Array{Elements: sliced}
+		case *object.String:
+			chars := []rune(left.Value)
+			start, end := sliceBounds(len(chars), low, high)
+			return &object.String{Value: string(chars[start:end])}
+		default:
+			return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+// Start and end of a slice of n elements, low and high are integers or null
+func sliceBounds(n int, low, high object.Object) (int, int) {
+	bound := func(b object.Object, missing int) int {
+		if b == NULL {
+			return missing
+		}
+		i := b.(*object.Integer).Value
+		if i < 0 {
+			i += int64(n)
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > int64(n) {
+			return n
+		}
+		return int(i)
+	}
+
+	start, end := bound(low, 0), bound(high, n)
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
 func evalArrayIndexExpression(left, index object.Object) object.Object {
 	idx := index.(*object.Integer).Value
 	array := left.(*object.Array).Elements
@@ -352,42 +859,135 @@ func evalArrayIndexExpression(left, index object.Object) object.Object {
 	return array[idx]
 }
 
+// For compound operators like += the current value is read first,
+// then the value is evaluated and the operator applied
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+		case *ast.Identifier:
+			var current object.Object
+			if node.Operator != "=" {
+				val, ok := env.Get(target.Value)
+				if !ok {
+					return newError("identifier not found: " + target.Value)
+				}
+				current = val
+			}
+
+			value := evalAssignedValue(node, current, env)
+			if isError(value) {
+				return value
+			}
+
+			if _, ok := env.Assign(target.Value, value); !ok {
+				return newError("identifier not found: " + target.Value)
+			}
+			return value
+		case *ast.IndexExpression:
+			left := Eval(target.Left, env)
+			if isError(left) {
+				return left
+			}
+
+			index := Eval(target.Index, env)
+			if isError(index) {
+				return index
+			}
+
+			var current object.Object
+			if node.Operator != "=" {
+				current = evalIndexExpression(left, index)
+				if isError(current) {
+					return current
+				}
+			}
+
+			value := evalAssignedValue(node, current, env)
+			if isError(value) {
+				return value
+			}
+			return evalIndexAssignment(left, index, value)
+		default:
+			return newError("cannot assign to %s", node.Target)
+	}
+}
+
+// Evaluate the right side, combined with the current value for compound operators
+func evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	value := Eval(node.Value, env)
+	if isError(value) || node.Operator == "=" {
+		return value
+	}
+
+	// "+=" applies "+"
+	operator := node.Operator[:len(node.Operator)-1]
+	return allocate(env, evalInfixExpression(operator, current, value))
+}
+
+func evalIndexAssignment(left, index, value object.Object) object.Object {
+	switch {
+		case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+			array := left.(*object.Array)
+			idx := index.(*object.Integer).Value
+
+			if array.Frozen {
+				return newError("cannot change an array that is a hash key")
+			}
+			if idx < 0 || idx >= int64(len(array.Elements)) {
+				return newError("index out of range: %d, length %d", idx, len(array.Elements))
+			}
+			array.Elements[idx] = value
+			return value
+		case left.Type() == object.HASH_OBJ:
+			hash := left.(*object.Hash)
+			if hash.Frozen {
+				return newError("cannot change a hash that is a hash key or a namespace")
+			}
+			key, ok := object.AsHashable(index)
+			if !ok {
+				return newError("unusable as hash key: %s", index.Type())
+			}
+
+			hash.Set(key, value)
+			return value
+		default:
+			return newError("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key:key, Value:value}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs:pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	hashKey := key.HashKey()
-	pair, ok := hashObject.Pairs[hashKey]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
-	return pair.Value
+	return value
 }
\ No newline at end of file
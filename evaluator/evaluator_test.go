@@ -1,10 +1,16 @@
-package evaluator
+package evaluator_test
 
 import(
+	"context"
 	"testing"
-	"../lexer"
-	"../object"
-	"../parser"
+	"time"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
 )
 
 // Test Integer
@@ -28,6 +34,14 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"10 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 10 % 4 * 3", 8},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 * 2 ** 2", 12},
+		{"5 ** 0", 1},
 	}
 
 	for _, tt := range tests {
@@ -52,6 +66,49 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool{
 	return true
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input 		string
+		expected 	float64
+	} {
+		{"3.14", 3.14},
+		{".5", 0.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"(1 + 2 + 3) / 4.0", 1.5},
+		{"2.5e3 - 500", 2000.0},
+		{"float(7) / 2", 3.5},
+		{`float("0.25")`, 0.25},
+		{"7.5 % 2", 1.5},
+		{"2 ** -1", 0.5},
+		{"4 ** 0.5", 2.0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool{
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+               result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 // Test Boolean
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
@@ -77,6 +134,30 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{`{1: true}[1.0]`, true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{`"a" < "b"`, true},
+		{`"abc" > "abd"`, false},
+		{`"a" <= "a"`, true},
+		{`"b" >= "a"`, true},
+		{`"x" == "x"`, true},
+		{`"x" != "x"`, false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"if (false) { 1 } || false", false},
+		{"1 && \"a\"", true},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"false && 1 + true", false},
+		{"true || 1 + true", true},
+		{"let x = 1; false && (x = 2); x == 1", true},
 	}
 
 	for _, tt := range tests {
@@ -146,7 +227,7 @@ func TestIfElseExpression(t *testing.T) {
 }
 
 func testNullObject(t *testing.T, obj object.Object) bool{
-	if obj != NULL {
+	if obj != evaluator.NULL {
 		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
 		return false
 	}
@@ -177,12 +258,47 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+// Engine used by testEval, TestVM switches it to the bytecode vm
+var testEngine = "eval"
+
 func testEval(input string) object.Object {
+	return testEvalWithContext(input, nil)
+}
+
+// Evaluate with the limits of ctx, nil for none
+func testEvalWithContext(input string, ctx *object.EvalContext) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+
+	if testEngine == "vm" {
+		return testRun(program, ctx)
+	}
+
 	env := object.NewEnvironment()
-	return Eval(program, env)
+	if ctx != nil {
+		return evaluator.EvalWithContext(ctx, program, env)
+	}
+	return evaluator.Eval(program, env)
+}
+
+func testRun(program *ast.Program, ctx *object.EvalContext) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		if compileErr, ok := err.(*compiler.Error); ok {
+			return &object.Error{Message: compileErr.Message, Pos: compileErr.Pos}
+		}
+		return &object.Error{Message: err.Error()}
+	}
+
+	machine := vm.New(comp.Bytecode())
+	if ctx != nil {
+		machine.SetContext(ctx)
+	}
+	if err := machine.Run(); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return machine.LastPoppedStackElem()
 }
 
 func TestErrorHandling(t *testing.T) {
@@ -236,6 +352,34 @@ func TestErrorHandling(t *testing.T) {
     		`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"-true + 1.5",
+			"unknown operator: -BOOLEAN",
+		},
+		{
+			"1.5 + true",
+			"type mismatch: FLOAT + BOOLEAN",
+		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"let x = 0; 5 % x",
+			"division by zero",
+		},
+		{
+			"first([])",
+			"`first` called on an empty array",
+		},
+		{
+			"fn(a, b) { a }(1)",
+			"wrong number of arguments. got=1, want=2",
+		},
+		{
+			"let f = fn(x) { 10 / x }; f(0) + 1",
+			"division by zero",
+		},
 	}
 
 	for _, tt := range tests {
@@ -361,8 +505,23 @@ func TestBuiltInFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo")`, 5},
+		{`len(bytes("héllo"))`, 6},
+		{`bytes("é")[1]`, 169},
+		{`bytes(1)`, "argument to `bytes` must be STRING, got INTEGER"},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`push(1, 2)`, "argument 1 to `push` must be ARRAY, got INTEGER"},
+		{`rest("a")`, "argument to `rest` must be ARRAY, got STRING"},
+		{`int(3.9)`, 3},
+		{`int(-3.9)`, -3},
+		{`int("42")`, 42},
+		{`int("4x")`, "could not parse \"4x\" as integer"},
+		{`int(1e30)`, "could not convert 1e+30 to integer"},
+		{`int(-1e30)`, "could not convert -1e+30 to integer"},
+		{`int(0.0 / 0.0)`, "could not convert NaN to integer"},
+		{`int(-9223372036854775808.0)`, -9223372036854775808},
+		{`float(true)`, "argument to `float` not supported, got BOOLEAN"},
 	}
 
 	for _, tt := range tests {
@@ -386,6 +545,129 @@ func TestBuiltInFunctions(t *testing.T) {
 	}
 }
 
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string		// the value, or the message of the error
+	} {
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map(["a", "bc"], len)`, "[1, 2]"},
+		{`let n = 10; map([1, 2], fn(x) { x + n })`, "[11, 12]"},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, "[2, 4]"},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x })`, "6"},
+		{`reduce([], fn(acc, x) { acc + x }, 0)`, "0"},
+		{`reduce(["a", "b"], fn(acc, x) { acc + x }, ">")`, ">ab"},
+		{`reduce([], fn(acc, x) { acc + x })`, "`reduce` called on an empty array without an initial value"},
+		{`let sum = 0; each([1, 2, 3], fn(x) { sum += x }); sum`, "6"},
+		{`find([1, 5, 10], fn(x) { x > 3 })`, "5"},
+		{`find([1], fn(x) { x > 3 })`, "null"},
+		{`any([1, 2], fn(x) { x > 1 })`, "true"},
+		{`all([1, 2], fn(x) { x > 1 })`, "false"},
+		{`all([], fn(x) { false })`, "true"},
+		{`sort([3, 1.5, 2])`, "[1.5, 2, 3]"},
+		{`sort(["b", "a", "c"])`, "[a, b, c]"},
+		{`sort([[2], [1, 5], [1]])`, "[[1], [1, 5], [2]]"},
+		{`sort([3, 1, 2], fn(a, b) { b - a })`, "[3, 2, 1]"},
+		{`sort([[1, "b"], [0, "a"], [1, "a"]], fn(a, b) { a[0] - b[0] })`, "[[0, a], [1, b], [1, a]]"},
+		{`let a = [2, 1]; sort(a); a`, "[2, 1]"},
+		{`sort([1, "a"])`, "`sort` cannot compare STRING and INTEGER"},
+		{`sort([1, 2], fn(a, b) { true })`, "comparator of `sort` must return INTEGER or FLOAT, got BOOLEAN"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{`range(4)`, "[0, 1, 2, 3]"},
+		{`range(2, 5)`, "[2, 3, 4]"},
+		{`range(5, 0, -2)`, "[5, 3, 1]"},
+		{`range(1, 2, 0)`, "step of `range` must not be 0"},
+		{`range()`, "wrong number of arguments. got=0, want 1 to 3"},
+		{`range(9223372036854775806, 9223372036854775807, 2)`, "[9223372036854775806]"},
+		{`range(-9223372036854775807 - 1, -9223372036854775807, 9223372036854775807)`, "[-9223372036854775808]"},
+		{`range(9223372036854775807, -9223372036854775807 - 1, -9223372036854775807 - 1)`, "[9223372036854775807, -1]"},
+		{`range(1000000000000)`, "`range` result too long"},
+		{`len(range(0, 10, 3))`, "4"},
+		{`flatten([1, [2, [3]], []])`, "[1, 2, [3]]"},
+		{`uniq([1, 2, 1.0, "a", [1], "a", [1]])`, "[1, 2, a, [1]]"},
+		{`reverse([1, 2, 3])`, "[3, 2, 1]"},
+		{`slice([1, 2, 3, 4], 1, 3)`, "[2, 3]"},
+		{`slice([1, 2, 3, 4], -2)`, "[3, 4]"},
+		{`slice([1, 2, 3], 2, 10)`, "[3]"},
+		{`slice([1, 2, 3], 2, 1)`, "[]"},
+		{`concat([1], [], [2, 3])`, "[1, 2, 3]"},
+		{`map(1, fn(x) { x })`, "argument 1 to `map` must be ARRAY, got INTEGER"},
+		{`map([1], 2)`, "argument 2 to `map` must be FUNCTION or BUILTIN, got INTEGER"},
+		{`map([1], fn(x) { x + true })`, "type mismatch: INTEGER + BOOLEAN"},
+		{`map([1], fn(x, y) { x })`, "wrong number of arguments. got=1, want=2"},
+		{`try { each([1], fn(x) { throw "boom" }) } catch (e) { e["message"] }`, "boom"},
+		{`map([1, 2], fn(x) { try { x + true } catch (e) { -x } })`, "[-1, -2]"},
+		{`map([[1, 2]], fn(a) { map(a, fn(x) { x * 10 }) })`, "[[10, 20]]"},
+		{`let f = fn(n) { if (n == 0) { return 0 }; reduce(map([n - 1], f), fn(a, b) { a + b }, n) }; f(4)`, "10"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string		// the value, or the message of the error
+	} {
+		{`strings["upper"] = fn(s) { "hijacked" }`, "cannot change a hash that is a hash key or a namespace"},
+		{`try { strings.upper = fn(s) { "hijacked" } } catch (e) { 0 }; strings.upper("a")`, "A"},
+		{`let names = []; for (name in strings) { names = push(names, name) }; names`,
+			"[chars, chr, contains, ends_with, index_of, join, lower, ord, repeat, replace, split, starts_with, substring, trim, upper]"},
+		{`{strings: 1}`, "unusable as hash key: HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEmptyBlocks(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string		// the value, or the message of the error
+	} {
+		{`let f = fn() {}; f()`, "null"},
+		{`let f = fn() {}; [f()]`, "[null]"},
+		{`let f = fn() { let x = 1 }; f()`, "null"},
+		{`if (true) {}`, "null"},
+		{`let f = fn() {}; if (f()) { 1 } else { 2 }`, "2"},
+		{`let f = fn() {}; !f()`, "true"},
+		{`let f = fn() {}; -f()`, "unknown operator: -NULL"},
+		{`let f = fn() {}; len(f())`, "argument to `len` not supported, got NULL"},
+		{`let f = fn() {}; f() == if (false) { 1 }`, "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -473,25 +755,139 @@ func TestHashLiterals(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{ 
-		(&object.String{Value: "one"}).HashKey(): 1, 
-		(&object.String{Value: "two"}).HashKey(): 2, 
-		(&object.String{Value: "three"}).HashKey(): 3, 
-		(&object.Integer{Value: 4}).HashKey(): 4,
-		TRUE.HashKey(): 5, 
-		FALSE.HashKey(): 6,
+	// In the order of the literal
+	expected := []object.Hashable{
+		&object.String{Value: "one"},
+		&object.String{Value: "two"},
+		&object.String{Value: "three"},
+		&object.Integer{Value: 4},
+		evaluator.TRUE,
+		evaluator.FALSE,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for i, expectedKey := range expected {
+		pair := result.Pairs()[i]
+		if pair.Key.Inspect() != expectedKey.Inspect() {
+			t.Errorf("pair %d has wrong key. want=%s, got=%s", i, expectedKey.Inspect(), pair.Key.Inspect())
+		}
+		value, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, int64(i + 1))
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{`{"b": 1, "a": 2, 3: 3}`, `{b: 1, a: 2, 3: 3}`},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, `{b: 4, a: 2, c: 3}`},
+		{`let h = {1: "int"}; h[1.0] = "float"; h`, `{1: float}`},
+		{`let keys = []; for (k in {"z": 1, "y": 2, "x": 3}) { keys = push(keys, k) }; keys`, `[z, y, x]`},
+		{`{"a": 1, "a": 2}`, `{a: 2}`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong hash. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string		// the value, or the message of the error
+	} {
+		{`{[1, 2]: "x"}[[1, 2]]`, "x"},
+		{`{[1, 2]: "x"}[[1.0, 2]]`, "x"},
+		{`{[1, 2]: "x"}[[2, 1]]`, "null"},
+		{`{[-9223372036854775807 - 1]: "min"}[[-(2.0 ** 63)]]`, "min"},
+		{`{[[1, 2], [3]]: "nested"}[[[1, 2], [3]]]`, "nested"},
+		{`{{"a": 1, "b": [2]}: "h"}[{"b": [2], "a": 1}]`, "h"},
+		{`{[1, 2]: "x", [1, 2]: "y"}`, "{[1, 2]: y}"},
+		{`let grid = {}; grid[[0, 1]] = "wall"; grid[[0, 1]]`, "wall"},
+		{`let k = [1]; let h = {k: "one"}; k[0] = 2; h[[1]]`, "one"},
+		{`let h = {[1]: 1}; for (k in h) { k[0] = 2 }`, "cannot change an array that is a hash key"},
+		{`let h = {{"a": 1}: 1}; for (k in h) { k["b"] = 2 }`, "cannot change a hash that is a hash key or a namespace"},
+		{`{[fn(x) { x }]: 1}`, "unusable as hash key: ARRAY"},
+		{`{"a": 1}[{"f": fn(x) { x }}]`, "unusable as hash key: HASH"},
+		{`let a = [1]; a[0] = a; {a: 1}`, "unusable as hash key: ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	interface{}		// bool, or the message of the error
+	} {
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, [2, "x"]] == [1, [2.0, "x"]]`, true},
+		{`[1] == [1, 2]`, false},
+		{`[1] == "1"`, false},
+		{`1 == "1"`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} != {"b": 1}`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b`, true},
+		{`let a = [1, 2]; a[0] = a; let b = [1, 3]; b[0] = b; a == b`, false},
+		{`let h = {}; h["self"] = h; let g = {}; g["self"] = g; h == g`, true},
+		{`[1, 2] < [1, 3]`, true},
+		{`[1, 2] < [1, 2, 0]`, true},
+		{`[2] > [1, 5]`, true},
+		{`["a", 1.5] <= ["a", 2]`, true},
+		{`[[1], 2] >= [[1], 2]`, true},
+		{`let a = [1]; a[0] = a; let b = [1]; b[0] = b; a <= b`, true},
+		{`[1, "a"] < [1, 2]`, "unknown operator: ARRAY < ARRAY"},
+		{`{} < {}`, "unknown operator: HASH < HASH"},
+		{`[1] < 2`, "type mismatch: ARRAY < INTEGER"},
+		{`9007199254740993 == 9007199254740992.0`, false},
+		{`9007199254740993 > 9007199254740992.0`, true},
+		{`9007199254740992.0 != 9007199254740992`, false},
+		{`let h = {9007199254740993: 1}; h[9007199254740992.0] == h[0]`, true},
+		{`1 < 0.0 / 0.0`, false},
+		{`1 != 0.0 / 0.0`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("%s: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+				}
+		}
 	}
 }
 
@@ -541,3 +937,530 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input			string
+		expectedPos		string
+	} {
+		{"5 + true;", "1:1"},
+		{"let a = 1;\n  foobar", "2:3"},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", "2:3"},
+		{"len(1)", "1:1"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(5000)", "1:46"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong error position. expected=%q, got=%q",
+				tt.expectedPos, errObj.Pos.String())
+		}
+	}
+}
+
+// The tests that do not depend on the tree-walker internals
+// run once more on the bytecode vm, which has to give the same results
+func TestVM(t *testing.T) {
+	tests := []struct {
+		name	string
+		test	func(*testing.T)
+	} {
+		{"EvalIntegerExpression", TestEvalIntegerExpression},
+		{"EvalFloatExpression", TestEvalFloatExpression},
+		{"EvalBooleanExpression", TestEvalBooleanExpression},
+		{"BangOperator", TestBangOperator},
+		{"IfElseExpression", TestIfElseExpression},
+		{"ReturnStatements", TestReturnStatements},
+		{"ErrorHandling", TestErrorHandling},
+		{"LetStatements", TestLetStatements},
+		{"FunctionApplication", TestFunctionApplication},
+		{"Closures", TestClosures},
+		{"StringLiteral", TestStringLiteral},
+		{"StringConcatenation", TestStringConcatenation},
+		{"BuiltInFunctions", TestBuiltInFunctions},
+		{"CollectionBuiltins", TestCollectionBuiltins},
+		{"Namespaces", TestNamespaces},
+		{"EmptyBlocks", TestEmptyBlocks},
+		{"ArrayLiterals", TestArrayLiterals},
+		{"ArrayIndexExpressions", TestArrayIndexExpressions},
+		{"HashLiterals", TestHashLiterals},
+		{"HashIndexExpressions", TestHashIndexExpressions},
+		{"HashOrder", TestHashOrder},
+		{"CompositeHashKeys", TestCompositeHashKeys},
+		{"StructuralEquality", TestStructuralEquality},
+		{"ErrorPositions", TestErrorPositions},
+		{"Loops", TestLoops},
+		{"Assignment", TestAssignment},
+		{"StringIndexExpressions", TestStringIndexExpressions},
+		{"SliceExpressions", TestSliceExpressions},
+		{"StringBuiltins", TestStringBuiltins},
+		{"StackTraces", TestStackTraces},
+		{"TryCatch", TestTryCatch},
+		{"CaughtErrorStack", TestCaughtErrorStack},
+		{"Limits", TestLimits},
+	}
+
+	testEngine = "vm"
+	defer func() { testEngine = "eval" }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, tt.test)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{"let i = 0; let sum = 0; while (i < 5) { let sum = sum + i; let i = i + 1; }; sum", 10},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; } sum", 6},
+		{`let s = ""; for (c in "abc") { let s = c + s; } s`, "cba"},
+		{`let n = 0; for (k in {"a": 1, "b": 2}) { let n = n + 1; } n`, 2},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } } i", 3},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let sum = sum + x; } sum", 8},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } 0 }; f()", 20},
+		{"let f = fn(n) { let i = 0; while (i < n) { let i = i + 1; } i }; f(1000)", 1000},
+		{"let sum = 0; for (x in [[1, 2], [3]]) { for (y in x) { if (y == 2) { break; } let sum = sum + y; } } sum", 4},
+		{"while (false) { 1 }", nil},
+		{"for (x in []) { x }", nil},
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+		{"for (x in [1]) { x + true }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				switch evaluated := evaluated.(type) {
+					case *object.String:
+						if evaluated.Value != expected {
+							t.Errorf("String has wrong value. got=%q, want=%q", evaluated.Value, expected)
+						}
+					case *object.Error:
+						if evaluated.Message != expected {
+							t.Errorf("wrong error message. expected=%q, got=%q", expected, evaluated.Message)
+						}
+					default:
+						t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+				}
+			default:
+				testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let a = 1; let b = 1; a = b = 5; a + b", 10},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f()", 2},
+		{"let f = fn() { let n = 0; let g = fn() { fn() { n += 10 } }; g()(); n }; f()", 10},
+		{"let f = fn() { f = 1; 2 }; let r = [f(), f]; r[0] * 10 + r[1]", 21},
+		{"let g = fn() { let f = fn() { f = 1; 2 }; let r = [f(), f]; r[0] * 10 + r[1] }; g()", 21},
+		{"let f = fn() { let g = fn() { f = 3 }; g(); f }; f()", 3},
+		{"let f = fn() { let n = 0; while (n < 2) { if (n == 1) { return f } f = 5; n += 1 } }; f()", 5},
+		{"let f = fn(n) { if (n == 0) { f = 9; 0 } else { f(n - 1) } }; f(3); f", 9},
+		{"let i = 0; while (i < 5) { i += 1 } i", 5},
+		{"let a = [1, 2, 3]; a[1] = 20; a[1] + a[2]", 23},
+		{"let a = [1, 2, 3]; a[0] += 9; a[0]", 10},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": 1}; h["a"] *= 7; h["a"]`, 7},
+		{"let a = [[1]]; a[0][0] = 4; a[0][0]", 4},
+		{"y = 1", "identifier not found: y"},
+		{"y += 1", "identifier not found: y"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1, length 1"},
+		{"let a = [1]; a[-1] = 2", "index out of range: -1, length 1"},
+		{`let h = {}; h[fn() { 1 }] = 1`, "unusable as hash key: FUNCTION"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING[INTEGER]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				switch evaluated := evaluated.(type) {
+					case *object.String:
+						if evaluated.Value != expected {
+							t.Errorf("String has wrong value. got=%q, want=%q", evaluated.Value, expected)
+						}
+					case *object.Error:
+						if evaluated.Message != expected {
+							t.Errorf("wrong error message. expected=%q, got=%q", expected, evaluated.Message)
+						}
+					default:
+						t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+				}
+		}
+	}
+}
+
+func TestRecoverFromPanic(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("boom", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		panic("boom")
+	}})
+
+	program := parser.New(lexer.New("let x = 1;\nboom()")).ParseProgram()
+	evaluated := evaluator.Eval(program, env)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "internal error: boom" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{`"abc"[0]`, "a"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"日本語"[2]`, "語"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, nil},
+		{`let größe = "x"; größe`, "x"},
+		{`let s = ""; for (c in "añb") { s = c + s } s`, "bña"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != expected {
+			t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+		}
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string		// the value, or the message of the error
+	} {
+		{`"héllo"[1:3]`, "él"},
+		{`"hello"[:2]`, "he"},
+		{`"hello"[3:]`, "lo"},
+		{`"hello"[-3:-1]`, "ll"},
+		{`"hello"[:]`, "hello"},
+		{`"hello"[4:2]`, ""},
+		{`"hello"[1:100]`, "ello"},
+		{`[1, 2, 3, 4][1:3]`, "[2, 3]"},
+		{`let a = [1, 2]; let b = a[:]; b[0] = 9; a`, "[1, 2]"},
+		{`"abc"["a":]`, "slice bounds must be INTEGER, got STRING"},
+		{`{"a": 1}[0:1]`, "slice operator not supported: HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string		// the value, or the message of the error
+	} {
+		{`strings.split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`strings.split("  a b\tc ")`, "[a, b, c]"},
+		{`strings.split("añb", "")`, "[a, ñ, b]"},
+		{`strings.join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`strings.join([], "-")`, ""},
+		{`strings.join(["a", 1], "-")`, "element 1 of the array to `strings.join` must be STRING, got INTEGER"},
+		{`strings.trim("  hi\n")`, "hi"},
+		{`strings.upper("héllo")`, "HÉLLO"},
+		{`strings.lower("MONKEY")`, "monkey"},
+		{`strings.contains("monkey", "key")`, "true"},
+		{`strings.starts_with("monkey", "mon")`, "true"},
+		{`strings.ends_with("monkey", "mon")`, "false"},
+		{`strings.index_of("héllo", "l")`, "2"},
+		{`strings.index_of("hello", "z")`, "-1"},
+		{`strings.replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`strings.repeat("ab", 3)`, "ababab"},
+		{`strings.repeat("ab", -1)`, "count of `strings.repeat` must not be negative, got -1"},
+		{`strings.repeat("ab", 9223372036854775807)`, "`strings.repeat` result too long"},
+		{`strings.substring("héllo", 1, 3)`, "él"},
+		{`strings.substring("hello", -2)`, "lo"},
+		{`strings.chars("日本")`, "[日, 本]"},
+		{`strings.ord("é")`, "233"},
+		{`strings.ord("ab")`, "argument to `strings.ord` must be one character, got 2"},
+		{`strings.chr(128512)`, "😀"},
+		{`strings.chr(-1)`, "-1 is not a unicode code point"},
+		{`strings.upper(1)`, "argument to `strings.upper` must be STRING, got INTEGER"},
+		{`map(strings.split("a b"), strings.upper)`, "[A, B]"},
+		{`let words = strings.split("b a b"); strings.join(sort(uniq(words)), ",")`, "a,b"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"1 + true", ""},
+		{
+			"let inner = fn(x) { x + true };\nlet outer = fn() { inner(1) };\nouter()",
+			"\tat inner (2:20)\n\tat outer (3:1)\n",
+		},
+		{
+			"let f = fn() { fn() { first([]) }() };\nf()",
+			"\tat <anonymous> (1:16)\n\tat f (2:1)\n",
+		},
+		{"let f = fn(a, b) { a };\nf(1)", ""},
+		{
+			"let f = fn(x) { x + true };\nlet g = fn() { map([1], f) };\ng()",
+			"\tat f (2:16)\n\tat g (3:1)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.StackTrace() != tt.expected {
+			t.Errorf("wrong stack trace for %q. expected=%q, got=%q",
+				tt.input, tt.expected, errObj.StackTrace())
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { 1 + true } catch (e) { 2 }", 2},
+		{"try { 1 + true } catch (e) { e[\"message\"] }", "type mismatch: INTEGER + BOOLEAN"},
+		{"try { len(1) } catch (e) { e[\"message\"] }", "argument to `len` not supported, got INTEGER"},
+		{"try { throw \"boom\" } catch (e) { e[\"message\"] }", "boom"},
+		{"try { throw 42 } catch (e) { e[\"message\"] }", "42"},
+		{"try { throw error(\"bad\") } catch (e) { e[\"message\"] }", "bad"},
+		{"error(\"bad\")[\"message\"]", "bad"},
+		{"len(error(\"bad\")[\"stack\"])", 0},
+		{"error(1)", "argument to `error` must be STRING, got INTEGER"},
+		{"throw \"boom\"; 1", "boom"},
+		{"let f = fn() { throw \"boom\" }; try { f() } catch (e) { e[\"message\"] }", "boom"},
+		{"try { throw \"a\" } catch (e) { throw e[\"message\"] + \"b\" }", "ab"},
+		{"try { try { throw \"a\" } catch (e) { throw e } } catch (e) { e[\"message\"] + \"!\" }", "a!"},
+		{"let x = 0; try { 1 } finally { x = 5 }; x", 5},
+		{"let x = 0; try { throw \"a\" } catch (e) { 2 } finally { x = 5 }; x", 5},
+		{"let x = 0; try { try { throw \"a\" } finally { x = 5 } } catch (e) { x + 1 }", 6},
+		{"let x = 0; try { try { 1 } catch (e) { throw \"b\" } finally { x = 5 } } catch (e) { 1 }; x", 5},
+		{"let x = 0; try { throw \"a\" } finally { x = 5 }", "a"},
+		{"try { 1 } finally { 2 }", 1},
+		{"try { 1 } finally { 1 + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"let x = 0; let f = fn() { try { return 1 } finally { x = 5 } }; f() + x", 6},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", 2},
+		{"let f = fn() { try { throw \"a\" } catch (e) { return 3 } 4 }; f()", 3},
+		{"let n = 0; for (x in [1, 2, 3]) { try { if (x == 2) { continue; } n += x } finally { n += 10 } } n", 34},
+		{"let n = 0; while (true) { try { break; } finally { n = 1 } } n", 1},
+		{"let n = 0; for (x in [1, 2]) { try { throw x } catch (e) { n += 1 } } n", 2},
+		{"let f = fn(x) { try { if (x > 0) { throw \"a\" } x } catch (e) { f(x - 1) } }; f(3)", 0},
+		{"let e = 1; try { throw \"a\" } catch (e) { 2 }; e[\"message\"]", "a"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				switch evaluated := evaluated.(type) {
+					case *object.String:
+						if evaluated.Value != expected {
+							t.Errorf("String has wrong value. got=%q, want=%q", evaluated.Value, expected)
+						}
+					case *object.Error:
+						if evaluated.Message != expected {
+							t.Errorf("wrong error message. expected=%q, got=%q", expected, evaluated.Message)
+						}
+					default:
+						t.Errorf("object is not String or Error for %q. got=%T (%+v)",
+							tt.input, evaluated, evaluated)
+				}
+		}
+	}
+}
+
+func TestCaughtErrorStack(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"try { 1 + true } catch (e) { e[\"stack\"] }", "[]"},
+		{
+			"let inner = fn() { throw \"a\" };\nlet outer = fn() { inner() };\ntry { outer() } catch (e) { e[\"stack\"] }",
+			"[inner (2:20), outer (3:7)]",
+		},
+		{
+			"let inner = fn() { throw \"a\" };\nlet outer = fn() { try { inner() } catch (e) { e[\"stack\"] } };\nouter()",
+			"[inner (2:26)]",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		stack, ok := evaluated.(*object.Array)
+		if !ok {
+			t.Errorf("object is not Array for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if stack.Inspect() != tt.expected {
+			t.Errorf("wrong stack for %q. expected=%q, got=%q", tt.input, tt.expected, stack.Inspect())
+		}
+	}
+
+	// Errors that are not caught keep the whole stack after a finally block
+	evaluated := testEval("let f = fn() { try { 1 + true } finally { 1 } };\nlet g = fn() { f() };\ng()")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	expected := "\tat f (2:16)\n\tat g (3:1)\n"
+	if errObj.StackTrace() != expected {
+		t.Errorf("wrong stack trace. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input		string
+		limits		object.Limits
+		expected	object.ErrorKind
+	} {
+		{"let f = fn() { f() }; f()", object.Limits{}, object.CALL_DEPTH_ERROR},
+		{"let f = fn() { f() }; try { f() } catch (e) { 1 }", object.Limits{}, object.CALL_DEPTH_ERROR},
+		{
+			"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(20)",
+			object.Limits{MaxCallDepth: 10},
+			object.CALL_DEPTH_ERROR,
+		},
+		{"while (true) { }", object.Limits{MaxSteps: 1000}, object.STEP_LIMIT_ERROR},
+		{"try { while (true) { } } finally { 1 }", object.Limits{MaxSteps: 1000}, object.STEP_LIMIT_ERROR},
+		{"let s = \"ab\"; while (true) { s += s }", object.Limits{MaxAllocations: 10000}, object.ALLOCATION_LIMIT_ERROR},
+		{"let a = []; while (true) { a = push(a, [1, 2]) }", object.Limits{MaxAllocations: 10000}, object.ALLOCATION_LIMIT_ERROR},
+		{`strings.repeat("ab", 1099511627776)`, object.Limits{MaxAllocations: 10000}, object.ALLOCATION_LIMIT_ERROR},
+		{`map([1], fn(x) { strings.repeat("ab", 6000) })`, object.Limits{MaxAllocations: 10000}, object.ALLOCATION_LIMIT_ERROR},
+	}
+
+	for _, tt := range tests {
+		ctx := object.NewEvalContext(context.Background(), tt.limits)
+		testLimitError(t, tt.input, testEvalWithContext(tt.input, ctx), tt.expected)
+	}
+
+	// Programs that stay within the limits are not affected
+	ctx := object.NewEvalContext(context.Background(), object.Limits{MaxCallDepth: 10, MaxSteps: 1000})
+	testIntegerObject(t, testEvalWithContext("let f = fn(n) { if (n > 0) { f(n - 1) } else { 7 } }; f(5)", ctx), 7)
+
+	// Only the call depth limits recursion, not the size of the vm stack
+	deep := []struct {
+		input		string
+		limits		object.Limits
+		expected	int64
+	} {
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(999)", object.Limits{}, 999},
+		{"let f = fn(a, b, c, n) { if (n == 0) { 0 } else { 1 + f(a, b, c, n - 1) } }; f(1, 2, 3, 999)", object.Limits{}, 999},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(4000)", object.Limits{MaxCallDepth: 5000}, 4000},
+	}
+	for _, tt := range deep {
+		ctx := object.NewEvalContext(context.Background(), tt.limits)
+		testIntegerObject(t, testEvalWithContext(tt.input, ctx), tt.expected)
+	}
+	ctx = object.NewEvalContext(context.Background(), object.Limits{})
+	testLimitError(t, "f(1000)", testEvalWithContext(
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1000)", ctx), object.CALL_DEPTH_ERROR)
+
+	elements := "1"
+	for i := 1; i < 3000; i++ {
+		elements += ", 1"
+	}
+	testIntegerObject(t, testEval("len([" + elements + "])"), 3000)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx = object.NewEvalContext(canceled, object.Limits{})
+	testLimitError(t, "1 + 1", testEvalWithContext("1 + 1", ctx), object.CANCELED_ERROR)
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	ctx = object.NewEvalContext(timeout, object.Limits{})
+	testLimitError(t, "while (true) { }", testEvalWithContext("while (true) { }", ctx), object.TIMEOUT_ERROR)
+}
+
+func testLimitError(t *testing.T, input string, obj object.Object, expected object.ErrorKind) {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Errorf("no error object returned for %q. got=%T(%+v)", input, obj, obj)
+		return
+	}
+	if errObj.Kind != expected {
+		t.Errorf("wrong error kind for %q. expected=%q, got=%q (%s)",
+			input, expected, errObj.Kind, errObj.Message)
+	}
+}
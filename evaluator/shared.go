@@ -0,0 +1,55 @@
+package evaluator
+
+// The bytecode vm shares these rules with the tree-walker,
+// so both engines give the same results and error messages
+
+import (
+	"monkey/object"
+	"monkey/token"
+)
+
+func EvalPrefixExpression(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func EvalInfixExpression(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func EvalIndexExpression(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+func EvalSliceExpression(left, low, high object.Object) object.Object {
+	return evalSliceExpression(left, low, high)
+}
+
+func EvalIndexAssignment(left, index, value object.Object) object.Object {
+	return evalIndexAssignment(left, index, value)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+func IterableItems(obj object.Object) ([]object.Object, object.Object) {
+	return iterableItems(obj)
+}
+
+func ErrorValue(errObj *object.Error) object.Object {
+	return errorValue(errObj)
+}
+
+func ThrownError(val object.Object) *object.Error {
+	return thrownError(val)
+}
+
+// Call a function from Go, its errors have no call position
+func ApplyFunction(fn object.Object, args []object.Object) object.Object {
+	return applyFunction(nil, fn, args, token.Position{})
+}
+
+// Like ApplyFunction, a builtin has to stay within the limits of ctx
+func ApplyFunctionWithContext(ctx *object.EvalContext, fn object.Object, args []object.Object) object.Object {
+	return applyFunction(ctx, fn, args, token.Position{})
+}
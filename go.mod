@@ -0,0 +1,3 @@
+module monkey
+
+go 1.13
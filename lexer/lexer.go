@@ -1,38 +1,106 @@
 package lexer
 
-import "../token"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"monkey/token"
+)
 
 type Lexer struct {
 	input 			string
+	filename		string		// reported in token positions, may be empty
 	position 		int 		// current position in input(points to current char)
 	readPosition 	int 		// current reading position in input(after current char)
-	ch 				byte
+	ch 				rune		// positions count bytes, ch is the whole utf-8 character
+	line			int			// line of the current char, starts from 1
+	column			int			// column of the current char in characters, starts from 1
+	keepComments	bool		// return comments as tokens instead of skipping them
+	errors			[]*Error
+}
+
+// Error is a problem found while reading the input
+type Error struct {
+	Message	string
+	Pos		token.Position
+	AtEOF	bool			// the rest of the input was read, e.g. by an unterminated string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Message
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// Same as New, but every token position also records the file name
+func NewWithFilename(filename string, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
+// Return comments as COMMENT tokens, e.g. for tools that format the source
+func (l *Lexer) KeepComments() {
+	l.keepComments = true
+}
+
+// Errors found while reading the input, e.g. an unterminated comment
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+func (l *Lexer) addError(pos token.Position, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	l.errors = append(l.errors, &Error{Message: msg, Pos: pos, AtEOF: l.ch == 0})
+}
+
 // Read the character and move to the next one
 func (l *Lexer) readChar() {
+	// Stay at the end of input once we reached it
+	if l.readPosition > len(l.input) {
+		return
+	}
+
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 1
+	} else {
+		l.column += 1
+	}
+
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
 }
 
 // Read the character
-func (l *Lexer) peekChar() byte{
-	if l.readPosition >= len(l.input) {
-		return 0
-	} else {
-		return l.input[l.readPosition]
+func (l *Lexer) peekChar() rune {
+	ch, _ := l.charAt(l.readPosition)
+	return ch
+}
+
+// Read the character after the next one
+func (l *Lexer) peekSecondChar() rune {
+	_, width := l.charAt(l.readPosition)
+	ch, _ := l.charAt(l.readPosition + width)
+	return ch
+}
+
+// The character starting at byte offset i and its width, 0 past the end of input
+func (l *Lexer) charAt(i int) (rune, int) {
+	if i >= len(l.input) {
+		return 0, 0
 	}
+	return utf8.DecodeRuneInString(l.input[i:])
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -43,26 +111,89 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// Any unicode letter, e.g. größe or 変数 are valid identifiers
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
 
-func (l *Lexer) readNumber() string {
+// Read an integer, or a float when it has a fraction or an exponent
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	var tokenType token.TokenType = token.INT
+
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+
+	// The dot only belongs to the number when a digit follows
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponent() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	return l.input[position:l.position], tokenType
+}
+
+// Check if the 'e' at the current char starts an exponent like e9, e+9 or e-9
+func (l *Lexer) isExponent() bool {
+	next := l.peekChar()
+	if next == '+' || next == '-' {
+		return isDigit(l.peekSecondChar())
+	}
+	return isDigit(next)
+}
+
+func isDigit(ch rune) bool {
+	return ch >= '0' && ch <= '9'
 }
 
-func isDigit(ch byte) bool {
-	return ch >= '0' && ch <= '9'	// Monkey only supports integers
+// Position of the current character
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset: l.position,
+		Line: l.line,
+		Column: l.column,
+	}
 }
 
 // Convert current character into token and move to the next one
 func (l *Lexer) NextToken() token.Token {
+	for {
+		l.skipWhiteSpace()
+
+		start := l.currentPosition()
+		var tok token.Token
+		if l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') || l.isShebang() {
+			tok = l.readComment()
+			if !l.keepComments {
+				continue
+			}
+		} else {
+			tok = l.readToken()
+		}
+
+		tok.Pos = start
+		tok.End = l.currentPosition()
+		return tok
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
-	l.skipWhiteSpace()
 
 	switch l.ch {
 		case '=':
@@ -75,9 +206,9 @@ func (l *Lexer) NextToken() token.Token {
 				tok = newToken(token.ASSIGN, l.ch)
 			}
 		case '+':
-			tok = newToken(token.PLUS, l.ch)
+			tok = l.readCompoundAssign(token.PLUS, token.PLUS_ASSIGN)
 		case '-':
-			tok = newToken(token.MINUS, l.ch)
+			tok = l.readCompoundAssign(token.MINUS, token.MINUS_ASSIGN)
 		case '!':
 			if(l.peekChar() == '=') {
 				ch := l.ch
@@ -87,13 +218,23 @@ func (l *Lexer) NextToken() token.Token {
 				tok = newToken(token.BANG, l.ch)
 			}
 		case '*':
-			tok = newToken(token.ASTERISK, l.ch)
+			if l.peekChar() == '*' {
+				tok = l.readDouble(token.POWER)
+			} else {
+				tok = l.readCompoundAssign(token.ASTERISK, token.ASTERISK_ASSIGN)
+			}
 		case '/':
-			tok = newToken(token.SLASH, l.ch)
+			tok = l.readCompoundAssign(token.SLASH, token.SLASH_ASSIGN)
+		case '&':
+			tok = l.readDouble(token.AND)
+		case '|':
+			tok = l.readDouble(token.OR)
+		case '%':
+			tok = newToken(token.PERCENT, l.ch)
 		case '<':
-			tok = newToken(token.LT, l.ch)
+			tok = l.readCompoundAssign(token.LT, token.LT_EQ)
 		case '>':
-			tok = newToken(token.GT, l.ch)
+			tok = l.readCompoundAssign(token.GT, token.GT_EQ)
 		case ';':
 			tok = newToken(token.SEMICOLON, l.ch)
 		case ':':
@@ -111,6 +252,9 @@ func (l *Lexer) NextToken() token.Token {
 		case '"':
 			tok.Type = token.STRING
 			tok.Literal = l.readString()
+		case '`':
+			tok.Type = token.STRING
+			tok.Literal = l.readRawString()
 		case '[':
 			tok = newToken(token.LBRACKET, l.ch)
 		case ']':
@@ -123,11 +267,11 @@ func (l *Lexer) NextToken() token.Token {
 				tok.Literal = l.readIdentifier()
 				tok.Type = token.LookUpIndent(tok.Literal)
 				return tok
-			} else if isDigit(l.ch) {
-				tok.Literal = l.readNumber()
-				// fmt.Printf("%q", tok.Literal)
-				tok.Type = token.INT
+			} else if isDigit(l.ch) || (l.ch == '.' && isDigit(l.peekChar())) {
+				tok.Literal, tok.Type = l.readNumber()
 				return tok
+			} else if l.ch == '.' {
+				tok = newToken(token.DOT, l.ch)
 			} else {
 				tok = newToken(token.ILLEGAL, l.ch)
 			}
@@ -136,6 +280,62 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// Operators that can be followed by '=', e.g. + or += and < or <=
+func (l *Lexer) readCompoundAssign(single, withAssign token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type:withAssign, Literal:string(ch) + string(l.ch)}
+	}
+	return newToken(single, l.ch)
+}
+
+// Operators made of the same character twice, e.g. &&, a single one is illegal
+func (l *Lexer) readDouble(double token.TokenType) token.Token {
+	if l.peekChar() == l.ch {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type:double, Literal:string(ch) + string(l.ch)}
+	}
+	return newToken(token.ILLEGAL, l.ch)
+}
+
+// A #! line at the very start of a script, e.g. #!/usr/bin/env monkey
+func (l *Lexer) isShebang() bool {
+	return l.position == 0 && l.ch == '#' && l.peekChar() == '!'
+}
+
+// Read a // comment or a shebang line up to the end of the line,
+// or a /* */ comment which may contain nested ones
+func (l *Lexer) readComment() token.Token {
+	start := l.currentPosition()
+	position := l.position
+
+	if l.peekChar() == '/' || l.ch == '#' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return token.Token{Type:token.COMMENT, Literal:l.input[position:l.position]}
+	}
+
+	l.readChar()
+	l.readChar()
+	for depth := 1; depth > 0; {
+		switch {
+			case l.ch == 0:
+				l.addError(start, "unterminated block comment")
+				return token.Token{Type:token.COMMENT, Literal:l.input[position:l.position]}
+			case l.ch == '/' && l.peekChar() == '*':
+				depth += 1
+				l.readChar()
+			case l.ch == '*' && l.peekChar() == '/':
+				depth -= 1
+				l.readChar()
+		}
+		l.readChar()
+	}
+	return token.Token{Type:token.COMMENT, Literal:l.input[position:l.position]}
+}
 
 func (l *Lexer)skipWhiteSpace() {
 	for (l.ch == '\t') || (l.ch == '\r') || (l.ch == '\n') || (l.ch == ' ') {
@@ -143,18 +343,95 @@ func (l *Lexer)skipWhiteSpace() {
 	}
 }
 
-func (l *Lexer) readString() string{
-	position := l.position + 1
-		for {
-			l.readChar()
-			if l.ch == '"' || l.ch == 0 {
-				break
-			}
+// Read a "string", replacing its escape sequences
+func (l *Lexer) readString() string {
+	start := l.currentPosition()
+	var out strings.Builder
+
+	for {
+		l.readChar()
+		switch l.ch {
+			case '"':
+				return out.String()
+			case 0:
+				l.addError(start, "unterminated string literal")
+				return out.String()
+			case '\\':
+				l.readEscape(&out)
+			default:
+				out.WriteRune(l.ch)
 		}
-		return l.input[position:l.position]
+	}
+}
 
+// Read the escape sequence after a backslash into out
+func (l *Lexer) readEscape(out *strings.Builder) {
+	pos := l.currentPosition()
+	l.readChar()
+
+	switch l.ch {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case '\\', '"':
+			out.WriteRune(l.ch)
+		case 'u':
+			l.readUnicodeEscape(pos, out)
+		case 0:
+			// Reported as an unterminated string by the caller
+		default:
+			l.addError(pos, "unknown escape sequence \\%c", l.ch)
+	}
+}
+
+// Read the {XXXX} part of a \u{XXXX} escape, the code point in hex
+func (l *Lexer) readUnicodeEscape(pos token.Position, out *strings.Builder) {
+	if l.peekChar() != '{' {
+		l.addError(pos, "expected { after \\u")
+		return
+	}
+	l.readChar()
+
+	position := l.position + 1
+	for l.peekChar() != '}' && l.peekChar() != '"' && l.peekChar() != 0 {
+		l.readChar()
+	}
+	digits := l.input[position:l.readPosition]
+
+	if l.peekChar() != '}' {
+		l.addError(pos, "unterminated unicode escape")
+		return
+	}
+	l.readChar()
+
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+		l.addError(pos, "invalid unicode escape \\u{%s}", digits)
+		return
+	}
+	out.WriteRune(rune(code))
+}
+
+// Read a `raw string`, it has no escapes and may span lines
+func (l *Lexer) readRawString() string {
+	start := l.currentPosition()
+	position := l.position + 1
+
+	for {
+		l.readChar()
+		if l.ch == '`' {
+			return l.input[position:l.position]
+		}
+		if l.ch == 0 {
+			l.addError(start, "unterminated raw string literal")
+			return l.input[position:l.position]
+		}
+	}
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token{
+func newToken(tokenType token.TokenType, ch rune) token.Token{
 	return token.Token{Type:tokenType, Literal:string(ch)}
 }
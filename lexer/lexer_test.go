@@ -2,7 +2,7 @@ package lexer
 
 import(
 	"testing"
-	"../token"
+	"monkey/token"
 )
 
 func TestNextToken(t *testing.T) {
@@ -12,7 +12,7 @@ func TestNextToken(t *testing.T) {
      	x + y;
 	};
 		let result = add(five, ten);
-		!-/*5;
+		!-/ *5;
 		5 < 10 > 5;
 		   
 		if (5 < 10) {
@@ -136,4 +136,373 @@ func TestNextToken(t *testing.T) {
 				i, tt.expectedLiteral, tok.Literal)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"ab\" + y"
+
+	tests := []struct {
+		expectedType	token.TokenType
+		expectedPos		token.Position
+		expectedEnd		token.Position
+	} {
+		{token.LET, testPos(0, 1, 1), testPos(3, 1, 4)},
+		{token.IDENT, testPos(4, 1, 5), testPos(5, 1, 6)},
+		{token.ASSIGN, testPos(6, 1, 7), testPos(7, 1, 8)},
+		{token.INT, testPos(8, 1, 9), testPos(9, 1, 10)},
+		{token.SEMICOLON, testPos(9, 1, 10), testPos(10, 1, 11)},
+		{token.STRING, testPos(13, 2, 3), testPos(17, 2, 7)},
+		{token.PLUS, testPos(18, 2, 8), testPos(19, 2, 9)},
+		{token.IDENT, testPos(20, 2, 10), testPos(21, 2, 11)},
+		{token.EOF, testPos(21, 2, 11), testPos(21, 2, 11)},
+		{token.EOF, testPos(21, 2, 11), testPos(21, 2, 11)},
+	}
+
+	l := NewWithFilename("test.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got %q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("test[%d] - pos wrong. expected=%+v, got %+v",
+				i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Errorf("test[%d] - end wrong. expected=%+v, got %+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func testPos(offset, line, column int) token.Position {
+	return token.Position{Filename: "test.mk", Offset: offset, Line: line, Column: column}
+}
+
+func TestNumberTokens(t *testing.T) {
+	input := `3.14 1e-9 .5 2.5E+3 42 7e 1.x`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+	} {
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, ".5"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "42"},
+		{token.INT, "7"},
+		{token.IDENT, "e"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got %q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got %q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCompoundAssignTokens(t *testing.T) {
+	input := `x += 1 -= 2 *= 3 /= 4 = +`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+	} {
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.ASSIGN, "="},
+		{token.PLUS, "+"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got %q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got %q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLogicalTokens(t *testing.T) {
+	input := `a && b || c & |`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+	} {
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got %q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got %q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndArithmeticTokens(t *testing.T) {
+	input := `a <= b >= c % d ** e *= f`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+	} {
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.POWER, "**"},
+		{token.IDENT, "e"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.IDENT, "f"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got %q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got %q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `let a = 1; // the answer is 42
+/* block /* nested */ still comment */ a / 2
+// last line`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+	} {
+		{token.LET, "let"},
+		{token.IDENT, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// the answer is 42"},
+		{token.COMMENT, "/* block /* nested */ still comment */"},
+		{token.IDENT, "a"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.COMMENT, "// last line"},
+		{token.EOF, ""},
+	}
+
+	// Without comments, the same tokens minus the comments
+	l := New(input)
+	for i, tt := range tests {
+		if tt.expectedType == token.COMMENT {
+			continue
+		}
+
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - wrong token. expected=%q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	l = New(input)
+	l.KeepComments()
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - wrong token. expected=%q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Errorf("unexpected lexer errors: %v", l.Errors())
+	}
+}
+
+func TestShebang(t *testing.T) {
+	l := New("#!/usr/bin/env monkey\nputs(1) # 2")
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+		expectedLine int
+	} {
+		{token.IDENT, "puts", 2},
+		{token.LPAREN, "(", 2},
+		{token.INT, "1", 2},
+		{token.RPAREN, ")", 2},
+		{token.ILLEGAL, "#", 2},	// only the first line can be a shebang
+		{token.INT, "2", 2},
+		{token.EOF, "", 2},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Pos.Line != tt.expectedLine {
+			t.Fatalf("test[%d] - wrong token. expected=%q %q on line %d, got %q %q on line %d",
+				i, tt.expectedType, tt.expectedLiteral, tt.expectedLine, tok.Type, tok.Literal, tok.Pos.Line)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("1 +\n  /* open /* nested */ never closed")
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 lexer error, got=%d (%v)", len(errors), errors)
+	}
+	if errors[0].Error() != "2:3: unterminated block comment" {
+		t.Errorf("wrong error. got=%q", errors[0])
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{`"plain"`, "plain"},
+		{`"a\nb\tc"`, "a\nb\tc"},
+		{`"quote \" and \\ backslash"`, `quote " and \ backslash`},
+		{`"{\"key\": 1}"`, `{"key": 1}`},
+		{`"\u{48}\u{e9}\u{1F600}"`, "Hé😀"},
+		{"`raw \\n \"text\"`", `raw \n "text"`},
+		{"`two\nlines`", "two\nlines"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.STRING {
+			t.Fatalf("tokentype wrong for %s. got %q", tt.input, tok.Type)
+		}
+		if tok.Literal != tt.expected {
+			t.Errorf("literal wrong for %s. expected=%q, got %q", tt.input, tt.expected, tok.Literal)
+		}
+		if len(l.Errors()) != 0 {
+			t.Errorf("unexpected lexer errors for %s: %v", tt.input, l.Errors())
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("expected EOF after %s. got %q", tt.input, next.Type)
+		}
+	}
+}
+
+func TestStringLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{`"open`, "1:1: unterminated string literal"},
+		{"x = `open\n", "1:5: unterminated raw string literal"},
+		{`"bad \q escape"`, `1:6: unknown escape sequence \q`},
+		{`"\u{110000}"`, `1:2: invalid unicode escape \u{110000}`},
+		{`"\u{zz}"`, `1:2: invalid unicode escape \u{zz}`},
+		{`"\u41"`, `1:2: expected { after \u`},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected 1 lexer error for %s, got=%d (%v)", tt.input, len(errors), errors)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestUnicodeInput(t *testing.T) {
+	input := "let größe = \"日本\"; 変数 ✓"
+
+	tests := []struct {
+		expectedType	token.TokenType
+		expectedLiteral	string
+		expectedColumn	int
+	} {
+		{token.LET, "let", 1},
+		{token.IDENT, "größe", 5},
+		{token.ASSIGN, "=", 11},
+		{token.STRING, "日本", 13},
+		{token.SEMICOLON, ";", 17},
+		{token.IDENT, "変数", 19},
+		{token.ILLEGAL, "✓", 22},
+		{token.EOF, "", 23},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - wrong token. expected=%q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("test[%d] - wrong column. expected=%d, got=%d", i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
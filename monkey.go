@@ -0,0 +1,184 @@
+// Package monkey runs Monkey programs from Go programs.
+//
+//	interp := monkey.New()
+//	interp.SetGlobal("greet", func(name string) string { return "hello " + name })
+//	result, err := interp.Run(`greet("monkey")`)
+//
+// Functions can also be registered as builtins, in namespaces if they want:
+//
+//	interp.Register("math.hypot", math.Hypot)
+//	result, err = interp.Run(`math.hypot(3, 4)`)
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+// Interpreter runs programs on the tree-walking evaluator.
+// Globals are kept from one Run to the next, like in the REPL.
+// An Interpreter must not be used by several goroutines at once
+type Interpreter struct {
+	env			*object.Environment
+	builtins	*object.Registry
+	limits		object.Limits
+}
+
+func New() *Interpreter {
+	i := &Interpreter{env: object.NewEnvironment(), builtins: evaluator.NewRegistry()}
+	i.env.SetBuiltins(i.builtins)
+	return i
+}
+
+// Limits of the following runs and calls, see object.Limits
+func (i *Interpreter) SetLimits(limits object.Limits) {
+	i.limits = limits
+}
+
+// Run the program and convert the value of its last statement to Go, see FromObject
+func (i *Interpreter) Run(src string) (interface{}, error) {
+	return i.RunContext(context.Background(), src)
+}
+
+// Like Run, the program stops with an error when ctx is done
+func (i *Interpreter) RunContext(ctx context.Context, src string) (interface{}, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Diagnostics: p.Errors()}
+	}
+
+	result := evaluator.EvalWithContext(object.NewEvalContext(ctx, i.limits), program, i.env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Err: errObj}
+	}
+	return FromObject(result), nil
+}
+
+// Call the global function fnName with args converted to Monkey values, see ToObject.
+// Without a global of that name it calls the builtin, e.g. strings.upper
+func (i *Interpreter) Call(fnName string, args ...interface{}) (result interface{}, err error) {
+	fn, ok := i.env.Get(fnName)
+	if !ok {
+		builtin, found := i.builtins.Function(fnName)
+		if !found {
+			return nil, fmt.Errorf("function not found: %s", fnName)
+		}
+		fn = builtin
+	}
+
+	params := make([]object.Object, len(args))
+	for n, arg := range args {
+		if params[n], err = ToObject(arg); err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %v", n + 1, fnName, err)
+		}
+	}
+
+	// The limits apply to the call like they do to a program
+	ctx := object.NewEvalContext(context.Background(), i.limits)
+	previous := i.env.SetContext(ctx)
+	defer i.env.SetContext(previous)
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+
+	returned := evaluator.ApplyFunctionWithContext(ctx, fn, params)
+	if errObj, ok := returned.(*object.Error); ok {
+		return nil, &RuntimeError{Err: errObj}
+	}
+	return FromObject(returned), nil
+}
+
+// Register the Go function fn as a builtin of this interpreter. A name with a
+// dot puts it in a namespace, math.sqrt is called as math.sqrt(x). The number
+// and types of the arguments are checked from the parameters of fn
+func (i *Interpreter) Register(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("builtin %s: %T is not a function", name, fn)
+	}
+	if name == "" {
+		return fmt.Errorf("builtin without a name")
+	}
+
+	i.builtins.Register(funcToBuiltin(name, v))
+	return nil
+}
+
+// Register every function of functions in the namespace, by their key
+func (i *Interpreter) RegisterNamespace(namespace string, functions map[string]interface{}) error {
+	for name, fn := range functions {
+		if err := i.Register(namespace + "." + name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register builtins written against the object package,
+// their Params tell the arguments to check before Fn is called
+func (i *Interpreter) RegisterBuiltin(builtins ...*object.Builtin) {
+	i.builtins.Register(builtins...)
+}
+
+// The builtins of this interpreter, sorted by name
+func (i *Interpreter) Builtins() []*object.Builtin {
+	return i.builtins.Functions()
+}
+
+// Bind a global variable to the Go value converted by ToObject
+func (i *Interpreter) SetGlobal(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("global %s: %v", name, err)
+	}
+	if b, ok := obj.(*object.Builtin); ok && b.Name == "" {
+		b.Name = name
+	}
+
+	i.env.Set(name, obj)
+	return nil
+}
+
+// The global variable converted by FromObject, false if it is not defined
+func (i *Interpreter) GetGlobal(name string) (interface{}, bool) {
+	obj, ok := i.env.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return FromObject(obj), true
+}
+
+// ParseError is returned for programs with syntax errors
+type ParseError struct {
+	Diagnostics	[]*parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	lines := make([]string, len(e.Diagnostics))
+	for n, d := range e.Diagnostics {
+		lines[n] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RuntimeError is an error of the program that was not caught
+type RuntimeError struct {
+	Err		*object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Err.Pos.IsValid() {
+		return e.Err.Pos.String() + ": " + e.Err.Message
+	}
+	return e.Err.Message
+}
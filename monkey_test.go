@@ -0,0 +1,336 @@
+package monkey_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"monkey"
+	"monkey/object"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{"1 + 2", int64(3)},
+		{"7 / 2.0", 3.5},
+		{"\"mon\" + \"key\"", "monkey"},
+		{"1 < 2", true},
+		{"if (false) { 1 }", nil},
+		{"[1, \"a\", [true]]", []interface{}{int64(1), "a", []interface{}{true}}},
+		{"{\"a\": 1, 2: false}", map[interface{}]interface{}{"a": int64(1), int64(2): false}},
+		{"{[1]: 2}[[1]]", int64(2)},
+	}
+
+	for _, tt := range tests {
+		result, err := monkey.New().Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Run(%q) = %#v, want %#v", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	interp := monkey.New()
+
+	_, err := interp.Run("let x = ;")
+	if _, ok := err.(*monkey.ParseError); !ok {
+		t.Errorf("expected a ParseError. got=%T (%v)", err, err)
+	} else if err.Error() != "1:9: no prefix parse function for ; found" {
+		t.Errorf("wrong parse error. got=%q", err.Error())
+	}
+
+	_, err = interp.Run("1 + true")
+	runtimeErr, ok := err.(*monkey.RuntimeError)
+	if !ok {
+		t.Fatalf("expected a RuntimeError. got=%T (%v)", err, err)
+	}
+	if runtimeErr.Error() != "1:1: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong runtime error. got=%q", runtimeErr.Error())
+	}
+
+	interp.SetLimits(object.Limits{MaxSteps: 100})
+	_, err = interp.Run("while (true) { }")
+	if runtimeErr, ok := err.(*monkey.RuntimeError); !ok || runtimeErr.Err.Kind != object.STEP_LIMIT_ERROR {
+		t.Errorf("expected a step limit error. got=%v", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	interp := monkey.New()
+
+	if err := interp.SetGlobal("names", []string{"a", "b"}); err != nil {
+		t.Fatalf("SetGlobal failed: %v", err)
+	}
+	if err := interp.SetGlobal("ages", map[string]int{"a": 3}); err != nil {
+		t.Fatalf("SetGlobal failed: %v", err)
+	}
+	if err := interp.SetGlobal("bad", struct{}{}); err == nil {
+		t.Errorf("SetGlobal of a struct did not fail")
+	}
+
+	result, err := interp.Run("let first_age = ages[names[0]]; len(names)")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result != int64(2) {
+		t.Errorf("wrong result. got=%#v", result)
+	}
+
+	// Globals are kept from one run to the next
+	age, ok := interp.GetGlobal("first_age")
+	if !ok || age != int64(3) {
+		t.Errorf("wrong global first_age. got=%#v, %t", age, ok)
+	}
+	if _, ok := interp.GetGlobal("missing"); ok {
+		t.Errorf("missing global was found")
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	interp := monkey.New()
+
+	interp.SetGlobal("repeat", strings.Repeat)
+	interp.SetGlobal("sum", func(xs ...float64) float64 {
+		total := 0.0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	})
+	interp.SetGlobal("fail", func(msg string) (int, error) {
+		return 0, errors.New(msg)
+	})
+	interp.SetGlobal("keys", func(m map[string]interface{}) int { return len(m) })
+
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{"repeat(\"ab\", 3)", "ababab"},
+		{"sum(1, 2.5, 3)", 6.5},
+		{"sum()", 0.0},
+		{"keys({\"a\": 1, \"b\": [2]})", int64(2)},
+		{"try { fail(\"boom\") } catch (e) { e[\"message\"] }", "boom"},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Run(%q) = %#v, want %#v", tt.input, result, tt.expected)
+		}
+	}
+
+	errorTests := []struct {
+		input		string
+		expected	string
+	} {
+		{"repeat(\"ab\")", "1:1: wrong number of arguments. got=1, want=2"},
+		{"repeat(1, 2)", "1:1: argument 1 to `repeat` must be STRING, got INTEGER"},
+		{"repeat(\"ab\", 1.5)", "1:1: argument 2 to `repeat` must be INTEGER, got FLOAT"},
+		{"sum(1, true)", "1:1: argument 2 to `sum` must be FLOAT or INTEGER, got BOOLEAN"},
+		{"keys({1: 2})", "1:1: argument 1: cannot use INTEGER as string"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := interp.Run(tt.input)
+		if err == nil {
+			t.Errorf("Run(%q) did not fail", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Run(%q) wrong error. expected=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	interp := monkey.New()
+
+	if err := interp.Register("math.sqrt", math.Sqrt); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	err := interp.RegisterNamespace("str", map[string]interface{}{
+		"upper": strings.ToUpper,
+		"join": strings.Join,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNamespace failed: %v", err)
+	}
+	interp.RegisterBuiltin(&object.Builtin{
+		Name: "double",
+		Params: []object.BuiltinParam{{Name: "n", Types: []object.ObjectType{object.INTEGER_OBJ}}},
+		Fn: func(args ...object.Object) object.Object {
+			return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+		},
+	})
+	if err := interp.Register("bad", 1); err == nil {
+		t.Errorf("Register of an integer did not fail")
+	}
+
+	tests := []struct {
+		input		string
+		expected	interface{}
+	} {
+		{"math.sqrt(16)", 4.0},
+		{"let sqrt = math[\"sqrt\"]; sqrt(2.25)", 1.5},
+		{"str.join([str.upper(\"a\"), \"b\"], \"-\")", "A-b"},
+		{"double(21)", int64(42)},
+		{"len(\"abc\")", int64(3)},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Run(%q) = %#v, want %#v", tt.input, result, tt.expected)
+		}
+	}
+
+	errorTests := []struct {
+		input		string
+		expected	string
+	} {
+		{"math.sqrt()", "1:1: wrong number of arguments. got=0, want=1"},
+		{"math.sqrt(\"x\")", "1:1: argument to `math.sqrt` must be FLOAT or INTEGER, got STRING"},
+		{"double(1.5)", "1:1: argument to `double` must be INTEGER, got FLOAT"},
+		{"math.cbrt(8)", "1:1: not a function: NULL"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := interp.Run(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Run(%q) wrong error. expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// Builtins belong to their interpreter
+	if _, err := monkey.New().Run("math.sqrt(4)"); err == nil {
+		t.Errorf("math.sqrt was registered in another interpreter")
+	}
+
+	names := []string{}
+	for _, b := range interp.Builtins() {
+		if strings.HasPrefix(b.Name, "math.") || strings.HasPrefix(b.Name, "str.") || b.Name == "double" {
+			names = append(names, b.Signature())
+		}
+	}
+	expected := []string{"double(n INTEGER)", "math.sqrt(FLOAT|INTEGER)",
+		"str.join(ARRAY, STRING)", "str.upper(STRING)"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong builtins. expected=%q, got=%q", expected, names)
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := monkey.New()
+	if _, err := interp.Run("let add = fn(a, b) { a + b }; let boom = fn() { 1 + true }"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	result, err := interp.Call("add", 2, 3)
+	if err != nil || result != int64(5) {
+		t.Errorf("Call(add) = %#v, %v", result, err)
+	}
+
+	result, err = interp.Call("add", "mon", "key")
+	if err != nil || result != "monkey" {
+		t.Errorf("Call(add) = %#v, %v", result, err)
+	}
+
+	if _, err := interp.Call("boom"); err == nil || err.Error() != "1:49: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error of Call(boom). got=%v", err)
+	}
+	if _, err := interp.Call("missing"); err == nil {
+		t.Errorf("Call of a missing function did not fail")
+	}
+
+	// Builtins, by their full name, unless a global has the name
+	interp.Register("math.neg", func(n int64) int64 { return -n })
+	calls := []struct {
+		fnName		string
+		args		[]interface{}
+		expected	interface{}
+	} {
+		{"len", []interface{}{"abc"}, int64(3)},
+		{"strings.upper", []interface{}{"abc"}, "ABC"},
+		{"math.neg", []interface{}{int64(2)}, int64(-2)},
+	}
+	for _, tt := range calls {
+		result, err := interp.Call(tt.fnName, tt.args...)
+		if err != nil || result != tt.expected {
+			t.Errorf("Call(%s) = %#v, %v", tt.fnName, result, err)
+		}
+	}
+
+	if _, err := interp.Run("let len = fn(x) { 42 }"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result, err := interp.Call("len", "abc"); err != nil || result != int64(42) {
+		t.Errorf("Call(len) = %#v, %v", result, err)
+	}
+	if _, err := interp.Call("strings"); err == nil {
+		t.Errorf("Call of a namespace did not fail")
+	}
+
+	interp.SetLimits(object.Limits{MaxAllocations: 100})
+	if _, err := interp.Call("strings.repeat", "ab", 1000); err == nil || err.Error() != "allocation limit exceeded" {
+		t.Errorf("wrong error of Call(strings.repeat). got=%v", err)
+	}
+}
+
+func TestCyclesFromObject(t *testing.T) {
+	interp := monkey.New()
+	if _, err := interp.Run("let a = [1]; a[0] = a; let h = {}; h[\"self\"] = h; 1"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	result, err := interp.Run("a")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	elements := result.([]interface{})
+	if array, ok := elements[0].(*object.Array); !ok || array.Inspect() != "[[...]]" {
+		t.Errorf("cycle not kept as a Monkey value. got=%#v", elements[0])
+	}
+
+	result, err = interp.Run("h")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, ok := result.(map[interface{}]interface{})["self"].(*object.Hash); !ok {
+		t.Errorf("cycle not kept as a Monkey value. got=%#v", result)
+	}
+}
+
+func TestCompositeKeysFromObject(t *testing.T) {
+	result, err := monkey.New().Run("{[1, 2]: \"pair\"}")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	hash := result.(map[interface{}]interface{})
+	if len(hash) != 1 {
+		t.Fatalf("wrong number of pairs. got=%d", len(hash))
+	}
+	for key, value := range hash {
+		array, ok := key.(*object.Array)
+		if !ok || array.Inspect() != "[1, 2]" || value != "pair" {
+			t.Errorf("wrong pair. got=%#v: %#v", key, value)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package object
+
+import (
+	"context"
+)
+
+// Limits of an evaluation, e.g. of code that cannot be trusted.
+// Zero values mean no limit, except for MaxCallDepth
+type Limits struct {
+	MaxCallDepth	int			// nested function calls, DefaultMaxCallDepth if 0
+	MaxSteps		int64		// evaluated nodes or executed instructions
+	MaxAllocations	int64		// approximate bytes of the strings, arrays and hashes created
+}
+
+// Deep recursion fails before it can exhaust the stack of the host
+const DefaultMaxCallDepth = 1000
+
+// Cancellation is only checked every few steps, it is not free
+const cancelCheckInterval = 1024
+
+// EvalContext is the state of one evaluation, it stops the evaluation
+// with an error once ctx is done or one of the limits is reached
+type EvalContext struct {
+	ctx			context.Context
+	limits		Limits
+
+	depth		int
+	steps		int64
+	allocated	int64
+}
+
+func NewEvalContext(ctx context.Context, limits Limits) *EvalContext {
+	if limits.MaxCallDepth == 0 {
+		limits.MaxCallDepth = DefaultMaxCallDepth
+	}
+	return &EvalContext{ctx: ctx, limits: limits}
+}
+
+// Count one step of the evaluation. A nil context has no limits
+func (c *EvalContext) Step() *Error {
+	if c == nil {
+		return nil
+	}
+
+	c.steps += 1
+	if c.limits.MaxSteps > 0 && c.steps > c.limits.MaxSteps {
+		return newLimitError(STEP_LIMIT_ERROR, "step limit exceeded")
+	}
+
+	if (c.steps-1) % cancelCheckInterval == 0 {
+		switch c.ctx.Err() {
+			case context.Canceled:
+				return newLimitError(CANCELED_ERROR, "evaluation canceled")
+			case context.DeadlineExceeded:
+				return newLimitError(TIMEOUT_ERROR, "evaluation timed out")
+		}
+	}
+	return nil
+}
+
+// Enter a function call, every successful Call needs a Return
+func (c *EvalContext) Call() *Error {
+	if c == nil {
+		return nil
+	}
+
+	if c.depth >= c.limits.MaxCallDepth {
+		return newLimitError(CALL_DEPTH_ERROR, "stack overflow")
+	}
+	c.depth += 1
+	return nil
+}
+
+func (c *EvalContext) Return() {
+	if c == nil {
+		return
+	}
+	c.depth -= 1
+}
+
+// Count a new value against the allocation limit
+func (c *EvalContext) Allocate(obj Object) *Error {
+	if c == nil {
+		return nil
+	}
+
+	c.allocated += sizeOf(obj)
+	if c.limits.MaxAllocations > 0 && c.allocated > c.limits.MaxAllocations {
+		return newLimitError(ALLOCATION_LIMIT_ERROR, "allocation limit exceeded")
+	}
+	return nil
+}
+
+// Check that size more bytes stay within the allocation limit, before a large
+// value is built. Allocate counts the value once it is there
+func (c *EvalContext) Reserve(size int64) *Error {
+	if c == nil {
+		return nil
+	}
+
+	if c.limits.MaxAllocations > 0 && size > c.limits.MaxAllocations - c.allocated {
+		return newLimitError(ALLOCATION_LIMIT_ERROR, "allocation limit exceeded")
+	}
+	return nil
+}
+
+// Rough number of bytes of a value, not counting the values it contains
+func sizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+		case *String:
+			return 16 + int64(len(obj.Value))
+		case *Array:
+			return 24 + 16 * int64(len(obj.Elements))
+		case *Hash:
+			return 48 + 64 * int64(obj.Len())
+	}
+	return 0
+}
+
+func newLimitError(kind ErrorKind, message string) *Error {
+	return &Error{Message: message, Kind: kind}
+}
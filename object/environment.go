@@ -1,8 +1,12 @@
 package object
 
+import "sort"
+
 type Environment struct {
-	store	map[string]Object
-	outer	*Environment
+	store		map[string]Object
+	outer		*Environment
+	context		*EvalContext		// only set on the outermost environment
+	builtins	*Registry			// only set on the outermost environment
 }
 
 func NewEnvironment() *Environment {
@@ -16,6 +20,40 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// Limits of the evaluation running in the environment, nil if there are none
+func (e *Environment) Context() *EvalContext {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.context
+}
+
+// Set the limits of the evaluations in the environment and the
+// environments it encloses, returns the ones set before
+func (e *Environment) SetContext(c *EvalContext) *EvalContext {
+	for e.outer != nil {
+		e = e.outer
+	}
+	previous := e.context
+	e.context = c
+	return previous
+}
+
+// The builtins programs in the environment can use, nil for the default ones
+func (e *Environment) Builtins() *Registry {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.builtins
+}
+
+func (e *Environment) SetBuiltins(r *Registry) {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.builtins = r
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -27,4 +65,28 @@ func (e *Environment) Get(name string) (Object, bool) {
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
+}
+
+// The names bound in this environment, not in the ones around it, sorted
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Update the binding in the scope that holds it,
+// returns false if the name is not bound anywhere
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return val, true
+	}
+
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return nil, false
 }
\ No newline at end of file
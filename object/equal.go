@@ -0,0 +1,222 @@
+package object
+
+import "math"
+
+// Equaler is implemented by objects that decide what they are equal to.
+// Equal is only called with objects that are not the same object
+type Equaler interface {
+	Equal(other Object) bool
+}
+
+// Comparer is implemented by objects that can be ordered. Compare gives
+// -1, 0 or 1 when the object is less than, equal to or greater than other,
+// false when the two cannot be ordered
+type Comparer interface {
+	Compare(other Object) (int, bool)
+}
+
+// Whether a and b are equal: numbers by value, so 1 equals 1.0, arrays by
+// their elements and hashes by their pairs, in any order. Structures that
+// contain themselves are equal when they have the same shape and values.
+// Other objects are equal if their Equal says so, or if they are the same object
+func Equal(a, b Object) bool {
+	return equal(a, b, map[[2]Object]bool{})
+}
+
+// comparing holds the arrays and hashes being compared further up,
+// meeting them again means going around a cycle
+func equal(a, b Object, comparing map[[2]Object]bool) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+		case *Array:
+			o, ok := b.(*Array)
+			if !ok || len(a.Elements) != len(o.Elements) {
+				return false
+			}
+			if comparing[[2]Object{a, o}] {
+				return true
+			}
+			comparing[[2]Object{a, o}] = true
+			defer delete(comparing, [2]Object{a, o})
+
+			for i, el := range a.Elements {
+				if !equal(el, o.Elements[i], comparing) {
+					return false
+				}
+			}
+			return true
+		case *Hash:
+			o, ok := b.(*Hash)
+			if !ok || a.Len() != o.Len() {
+				return false
+			}
+			if comparing[[2]Object{a, o}] {
+				return true
+			}
+			comparing[[2]Object{a, o}] = true
+			defer delete(comparing, [2]Object{a, o})
+
+			for _, pair := range a.pairs {
+				value, ok := o.Get(pair.Key.(Hashable))
+				if !ok || !equal(pair.Value, value, comparing) {
+					return false
+				}
+			}
+			return true
+		case Equaler:
+			return a.Equal(b)
+	}
+	return false
+}
+
+// The order of a and b, see Comparer. Numbers are ordered by value,
+// strings byte by byte and arrays element by element, a shorter array
+// first if it is the start of the longer one. Other objects are
+// ordered by their Compare
+func Compare(a, b Object) (int, bool) {
+	return compare(a, b, map[[2]Object]bool{})
+}
+
+func compare(a, b Object, comparing map[[2]Object]bool) (int, bool) {
+	switch a := a.(type) {
+		case *Array:
+			o, ok := b.(*Array)
+			if !ok {
+				return 0, false
+			}
+			if a == o || comparing[[2]Object{a, o}] {
+				return 0, true
+			}
+			comparing[[2]Object{a, o}] = true
+			defer delete(comparing, [2]Object{a, o})
+
+			for i := 0; i < len(a.Elements) && i < len(o.Elements); i++ {
+				c, ok := compare(a.Elements[i], o.Elements[i], comparing)
+				if !ok || c != 0 {
+					return c, ok
+				}
+			}
+			return compareInts(int64(len(a.Elements)), int64(len(o.Elements))), true
+		case Comparer:
+			return a.Compare(b)
+	}
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) (int, bool) {
+	switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		case a == b:
+			return 0, true
+	}
+	// NaN is not ordered
+	return 0, false
+}
+
+// Exact, converting i to a float would round integers beyond 2^53,
+// while their hash keys stay apart
+func compareIntFloat(i int64, f float64) (int, bool) {
+	switch {
+		case math.IsNaN(f):
+			return 0, false
+		case f >= 1<<63:
+			return -1, true
+		case f < -1<<63:
+			return 1, true
+	}
+
+	whole := math.Trunc(f)
+	if c := compareInts(i, int64(whole)); c != 0 {
+		return c, true
+	}
+	return compareFloats(0, f - whole)
+}
+
+func (n *Null) Equal(other Object) bool {
+	_, ok := other.(*Null)
+	return ok
+}
+
+func (b *Boolean) Equal(other Object) bool {
+	o, ok := other.(*Boolean)
+	return ok && b.Value == o.Value
+}
+
+func (i *Integer) Equal(other Object) bool {
+	c, ok := i.Compare(other)
+	return ok && c == 0
+}
+
+func (i *Integer) Compare(other Object) (int, bool) {
+	switch o := other.(type) {
+		case *Integer:
+			return compareInts(i.Value, o.Value), true
+		case *Float:
+			return compareIntFloat(i.Value, o.Value)
+	}
+	return 0, false
+}
+
+func (f *Float) Equal(other Object) bool {
+	c, ok := f.Compare(other)
+	return ok && c == 0
+}
+
+func (f *Float) Compare(other Object) (int, bool) {
+	switch o := other.(type) {
+		case *Integer:
+			c, ok := compareIntFloat(o.Value, f.Value)
+			return -c, ok
+		case *Float:
+			return compareFloats(f.Value, o.Value)
+	}
+	return 0, false
+}
+
+func (s *String) Equal(other Object) bool {
+	o, ok := other.(*String)
+	return ok && s.Value == o.Value
+}
+
+func (s *String) Compare(other Object) (int, bool) {
+	o, ok := other.(*String)
+	if !ok {
+		return 0, false
+	}
+	switch {
+		case s.Value < o.Value:
+			return -1, true
+		case s.Value > o.Value:
+			return 1, true
+	}
+	return 0, true
+}
+
+// Arrays and hashes are equal by their contents, see Equal
+func (a *Array) Equal(other Object) bool {
+	return Equal(a, other)
+}
+
+func (h *Hash) Equal(other Object) bool {
+	return Equal(h, other)
+}
+
+func (a *Array) Compare(other Object) (int, bool) {
+	return Compare(a, other)
+}
@@ -0,0 +1,192 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+)
+
+// Hash maps keys to values and keeps them in the order they were added.
+// Keys whose HashKey collides share a bucket and are compared to find
+// the right pair, so they never overwrite each other
+type Hash struct {
+	pairs		[]HashPair				// in insertion order
+	buckets		map[HashKey][]int		// indexes in pairs of the keys with that HashKey
+	Frozen		bool					// a copy that is a hash key or a namespace, it cannot be changed
+}
+
+func NewHash() *Hash {
+	return &Hash{buckets: make(map[HashKey][]int)}
+}
+
+func (h *Hash) Type() ObjectType {return HASH_OBJ}
+func (h *Hash) Inspect() string {
+	return inspect(h, map[Object]bool{})
+}
+
+func (h *Hash) inspect(printing map[Object]bool) string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range h.pairs {
+		pairs = append(pairs, fmt.Sprintf("%s: %s",
+               inspect(pair.Key, printing), inspect(pair.Value, printing)))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// Index in pairs of the key, -1 if it is not in the hash
+func (h *Hash) find(key Hashable, hashKey HashKey) int {
+	for _, i := range h.buckets[hashKey] {
+		if key.Equal(h.pairs[i].Key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	if i := h.find(key, key.HashKey()); i >= 0 {
+		return h.pairs[i].Value, true
+	}
+	return nil, false
+}
+
+// Set the value of the key. A key that is already there keeps its place
+// in the order and the key it was added with. Arrays and hashes are
+// copied, so changing them afterwards does not change the key
+func (h *Hash) Set(key Hashable, value Object) {
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+
+	hashKey := key.HashKey()
+	if i := h.find(key, hashKey); i >= 0 {
+		h.pairs[i].Value = value
+		return
+	}
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: freezeKey(key), Value: value})
+}
+
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// The pairs in insertion order. The slice belongs to the hash, use Set to change it
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
+// The object as a hash key, false if it cannot be one. Arrays can be keys
+// if their elements can, hashes if their values can. Structures that
+// contain themselves cannot be keys
+func AsHashable(obj Object) (Hashable, bool) {
+	return asHashable(obj, map[Object]bool{})
+}
+
+func asHashable(obj Object, visiting map[Object]bool) (Hashable, bool) {
+	switch obj := obj.(type) {
+		case *Array:
+			if obj.Frozen {
+				return obj, true
+			}
+			if visiting[obj] {
+				return nil, false
+			}
+			visiting[obj] = true
+			defer delete(visiting, obj)
+
+			for _, el := range obj.Elements {
+				if _, ok := asHashable(el, visiting); !ok {
+					return nil, false
+				}
+			}
+			return obj, true
+		case *Hash:
+			// Namespaces are frozen too, their functions cannot be keys
+			if visiting[obj] {
+				return nil, false
+			}
+			visiting[obj] = true
+			defer delete(visiting, obj)
+
+			for _, pair := range obj.pairs {
+				if _, ok := asHashable(pair.Value, visiting); !ok {
+					return nil, false
+				}
+			}
+			return obj, true
+	}
+	hashable, ok := obj.(Hashable)
+	return hashable, ok
+}
+
+// A copy of an array or hash key that cannot be changed, other keys as they are
+func freezeKey(key Hashable) Hashable {
+	switch key := key.(type) {
+		case *Array:
+			if key.Frozen {
+				return key
+			}
+			elements := make([]Object, len(key.Elements))
+			for i, el := range key.Elements {
+				elements[i] = freezeValue(el)
+			}
+			return &Array{Elements: elements, Frozen: true}
+		case *Hash:
+			if key.Frozen {
+				return key
+			}
+			frozen := NewHash()
+			for _, pair := range key.pairs {
+				frozen.Set(pair.Key.(Hashable), freezeValue(pair.Value))
+			}
+			frozen.Frozen = true
+			return frozen
+	}
+	return key
+}
+
+func freezeValue(value Object) Object {
+	if key, ok := value.(Hashable); ok {
+		return freezeKey(key)
+	}
+	return value
+}
+
+// Combines the keys of the elements in order
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	for _, el := range a.Elements {
+		writeHashKey(h, el.(Hashable).HashKey())
+	}
+	return HashKey{ObjectType: a.Type(), Value: h.Sum64()}
+}
+
+// Combines the keys of the pairs, in any order
+// since hashes with the same pairs are equal
+func (h *Hash) HashKey() HashKey {
+	var sum uint64
+	for _, pair := range h.pairs {
+		pairHash := fnv.New64a()
+		writeHashKey(pairHash, pair.Key.(Hashable).HashKey())
+		writeHashKey(pairHash, pair.Value.(Hashable).HashKey())
+		sum += pairHash.Sum64()
+	}
+	return HashKey{ObjectType: h.Type(), Value: sum}
+}
+
+func writeHashKey(h io.Writer, key HashKey) {
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], key.Value)
+	h.Write([]byte(key.ObjectType))
+	h.Write(value[:])
+}
@@ -6,7 +6,11 @@ import (
 	"bytes"
 	"strings"
 	"hash/fnv"
-	"../ast"
+	"math"
+	"strconv"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
 )
 
 type ObjectType string
@@ -16,15 +20,23 @@ type Object interface {
 	Inspect()	string
 }
 
+// Objects that can be keys of a Hash. Keys that are equal must have the
+// same HashKey, keys with the same HashKey are told apart with Equal.
+// Other types become hashable by implementing it too
 type Hashable interface {
+	Object
+	Equaler
 	HashKey() HashKey
 }
 
 const (
 	INTEGER_OBJ = "INTEGER"
+	FLOAT_OBJ = "FLOAT"
 	BOOLEAN_OBJ = "BOOLEAN"
 	NULL_OBJ 	= "NULL" 
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ = "BREAK"
+	CONTINUE_OBJ = "CONTINUE"
 	ERROR_OBJ = "ERROR"
 	FUNCTION_OBJ  = "FUNCTION"
 	STRING_OBJ	 = "STRING"
@@ -32,6 +44,7 @@ const (
 	ARRAY_OBJ = "ARRAY"
 	HASH_OBJ = "HASH"
 	HASHKEY_OBJ = "HASHKEY"
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 // -----------------------
@@ -43,6 +56,20 @@ type Integer struct {
 func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value)}
 func (i *Integer) Type() ObjectType {return INTEGER_OBJ}
 
+type Float struct {
+	Value float64
+}
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+
+	// Keep floats with integral values apart from integers, 3.0 instead of 3
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType {return FLOAT_OBJ}
+
 type Boolean struct{
 	Value bool
 }
@@ -59,14 +86,73 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string {return rv.Value.Inspect()}
 func (rv *ReturnValue) Type()	ObjectType {return RETURN_VALUE_OBJ}
 
+// Break and Continue travel up from the statement to the enclosing loop
+type Break struct {}
+func (b *Break) Inspect() string {return "break"}
+func (b *Break) Type() ObjectType {return BREAK_OBJ}
+
+type Continue struct {}
+func (c *Continue) Inspect() string {return "continue"}
+func (c *Continue) Type() ObjectType {return CONTINUE_OBJ}
+
 type Error struct {
 	Message string
+	Kind	ErrorKind			// empty for errors of the program itself
+	Pos		token.Position		// where the error happened in the source
+	Stack	[]StackFrame		// the calls that led to the error, innermost first
 }
 
-func (e *Error) Inspect() string {return "ERROR: " + e.Message}
+// Errors that are not the fault of the program, but stop it from going on
+type ErrorKind string
+
+const (
+	CANCELED_ERROR			ErrorKind = "CANCELED"
+	TIMEOUT_ERROR			ErrorKind = "TIMEOUT"
+	CALL_DEPTH_ERROR		ErrorKind = "CALL_DEPTH"
+	STEP_LIMIT_ERROR		ErrorKind = "STEP_LIMIT"
+	ALLOCATION_LIMIT_ERROR	ErrorKind = "ALLOCATION_LIMIT"
+)
+
+// Limits and cancellation end the program, try cannot catch these errors
+func (e *Error) Aborts() bool {
+	return e.Kind != ""
+}
+
+// A function call that was running when an error happened
+type StackFrame struct {
+	Function	string				// name from the let binding, or <anonymous>
+	Pos			token.Position		// where the function was called
+}
+
+const ANONYMOUS_FUNCTION = "<anonymous>"
+
+// Long stack traces, e.g. of runaway recursion, only show the innermost calls
+const maxStackTraceFrames = 20
+
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
 func (e *Error) Type()	  ObjectType {return ERROR_OBJ}
 
+// The call stack, one call per line, empty for errors outside of functions
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+
+	for i, frame := range e.Stack {
+		if i == maxStackTraceFrames {
+			fmt.Fprintf(&out, "\t... %d more\n", len(e.Stack) - i)
+			break
+		}
+		fmt.Fprintf(&out, "\tat %s (%s)\n", frame.Function, frame.Pos)
+	}
+	return out.String()
+}
+
 type Function struct {
+	Name	   string					// name from the let binding, empty for anonymous functions
 	Parameters []*ast.Identifier
 	Body	   *ast.BlockStatement
 	Env		   *Environment				// Contains local parameters inside the function
@@ -91,6 +177,32 @@ func (fn *Function) Inspect() string  {
 	return out.String()
 }
 
+// Function body compiled to bytecode, only used by the vm
+type CompiledFunction struct {
+	Name			string
+	Instructions	code.Instructions
+	NumLocals		int
+	NumParameters	int
+	Positions		map[int]token.Position		// instruction offset -> source position
+}
+
+func (cf *CompiledFunction) Type() ObjectType {return COMPILED_FUNCTION_OBJ}
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// A compiled function together with the free variables it captured.
+// It reports FUNCTION_OBJ so both engines show the same types to users
+type Closure struct {
+	Fn		*CompiledFunction
+	Free	[]Object
+}
+
+func (c *Closure) Type() ObjectType {return FUNCTION_OBJ}
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
 type String struct {
 	Value string
 }
@@ -99,25 +211,173 @@ func (s *String) Type() ObjectType {return STRING_OBJ}
 func (s *String) Inspect() string {return s.Value}
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name		string				// e.g. len, or math.sqrt for a function in a namespace
+	Params		[]BuiltinParam		// checked before Fn is called, nil if Fn checks the arguments itself
+	Variadic	bool				// the last parameter takes any number of arguments
+	Fn			BuiltinFunction
+	Apply		HigherOrderFunction	// used instead of Fn by builtins that call functions, e.g. map
+	Size		func(args ...Object) int64	// bytes of a result that can be large, checked against the allocation limit first
+}
+
+// A parameter of a builtin and the types it accepts, any type if Types is empty
+type BuiltinParam struct {
+	Name	string
+	Types	[]ObjectType
 }
 
 func (bi *Builtin) Type() ObjectType {return BUILTIN_OBJ}
 func (bi *Builtin) Inspect() string {return "builtin function"}
 
+// Check the number and types of the arguments, then call the function.
+// Functions given to it can only be called if they are builtins
+func (bi *Builtin) Call(args ...Object) Object {
+	return bi.CallWith(nil, applyBuiltin, args...)
+}
+
+// Like Call, apply calls the functions of the program given to the builtin
+// and the result has to fit the allocation limit of ctx
+func (bi *Builtin) CallWith(ctx *EvalContext, apply Applier, args ...Object) Object {
+	if bi.Params != nil || bi.Variadic {
+		if err := bi.checkArguments(args); err != nil {
+			return err
+		}
+	}
+	if bi.Size != nil {
+		if err := ctx.Reserve(bi.Size(args...)); err != nil {
+			return err
+		}
+	}
+	if bi.Apply != nil {
+		return bi.Apply(apply, args...)
+	}
+	return bi.Fn(args...)
+}
+
+func applyBuiltin(fn Object, args ...Object) Object {
+	if b, ok := fn.(*Builtin); ok {
+		return b.Call(args...)
+	}
+	return &Error{Message: fmt.Sprintf("not a function: %s", fn.Type())}
+}
+
+func (bi *Builtin) checkArguments(args []Object) *Error {
+	want := len(bi.Params)
+	if bi.Variadic {
+		if len(args) < want - 1 {
+			return &Error{Message: fmt.Sprintf(
+				"wrong number of arguments. got=%d, want at least %d", len(args), want - 1)}
+		}
+	} else if len(args) != want {
+		return &Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), want)}
+	}
+
+	for i, arg := range args {
+		param := bi.Params[len(bi.Params)-1]
+		if i < len(bi.Params) {
+			param = bi.Params[i]
+		}
+		if param.accepts(arg.Type()) {
+			continue
+		}
+
+		types := typeNames(param.Types, " or ")
+		if len(bi.Params) == 1 && !bi.Variadic {
+			return &Error{Message: fmt.Sprintf("argument to `%s` must be %s, got %s",
+				bi.Name, types, arg.Type())}
+		}
+		return &Error{Message: fmt.Sprintf("argument %d to `%s` must be %s, got %s",
+			i + 1, bi.Name, types, arg.Type())}
+	}
+	return nil
+}
+
+func (p BuiltinParam) accepts(t ObjectType) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, accepted := range p.Types {
+		if accepted == t {
+			return true
+		}
+	}
+	return false
+}
+
+// The name and parameters, e.g. push(array ARRAY, value) or puts(values...)
+func (bi *Builtin) Signature() string {
+	if bi.Params == nil && !bi.Variadic {
+		return bi.Name + "(...)"
+	}
+
+	params := make([]string, len(bi.Params))
+	for i, p := range bi.Params {
+		param := p.Name
+		if len(p.Types) > 0 {
+			param = strings.TrimSpace(param + " " + typeNames(p.Types, "|"))
+		}
+		if param == "" {
+			param = "value"
+		}
+		if bi.Variadic && i == len(bi.Params)-1 {
+			param += "..."
+		}
+		params[i] = param
+	}
+	return bi.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+func typeNames(types []ObjectType, sep string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, sep)
+}
+
 type BuiltinFunction func(args ...Object) Object
 
+// Calls a function of the running program, each engine has its own
+type Applier func(fn Object, args ...Object) Object
+
+type HigherOrderFunction func(apply Applier, args ...Object) Object
+
 type Array struct {
 	Elements	[]Object
+	Frozen		bool		// a copy that is a hash key, it cannot be changed
 }
 
 func (a *Array) Type() ObjectType {return ARRAY_OBJ}
 func (a *Array) Inspect()	string {
+	return inspect(a, map[Object]bool{})
+}
+
+// An array or hash that contains itself prints as [...] or {...} inside
+func inspect(obj Object, printing map[Object]bool) string {
+	switch obj := obj.(type) {
+		case *Array:
+			if printing[obj] {
+				return "[...]"
+			}
+			printing[obj] = true
+			defer delete(printing, obj)
+			return obj.inspect(printing)
+		case *Hash:
+			if printing[obj] {
+				return "{...}"
+			}
+			printing[obj] = true
+			defer delete(printing, obj)
+			return obj.inspect(printing)
+	}
+	return obj.Inspect()
+}
+
+func (a *Array) inspect(printing map[Object]bool) string {
 	var out bytes.Buffer
 
 	elements := []string{}
 	for _, tt := range a.Elements {
-		elements = append(elements, tt.Inspect())
+		elements = append(elements, inspect(tt, printing))
 	}
 
 	out.WriteString("[")
@@ -127,26 +387,8 @@ func (a *Array) Inspect()	string {
 	return out.String()
 }
 
-type Hash struct {
-	Pairs map[HashKey]HashPair
-}
-
-func (h *Hash) Type() ObjectType {return HASH_OBJ}
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-
-	pairs := []string{}
-	for _, pair := range h.Pairs{
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-               pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-	return out.String()
-}
-
+// The hash of a key, equal keys have the same HashKey. Different keys
+// can have it too, Hash tells them apart by comparing the keys
 type HashKey struct {
 	ObjectType 	ObjectType
 	Value 		uint64
@@ -167,6 +409,15 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{ObjectType:i.Type(), Value:uint64(i.Value)}
 }
 
+// Floats with integral values share the key of the equal integer,
+// so 1 and 1.0 address the same entry
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= -(1<<63) && f.Value < 1<<63 {
+		return HashKey{ObjectType:INTEGER_OBJ, Value:uint64(int64(f.Value))}
+	}
+	return HashKey{ObjectType:f.Type(), Value:math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -174,7 +425,7 @@ func (s *String) HashKey() HashKey {
 }
 
 func (hk HashKey) Type() ObjectType{return hk.ObjectType}
-func (hk HashKey) Inspect() string {return string(hk.Value)}
+func (hk HashKey) Inspect() string {return strconv.FormatUint(hk.Value, 10)}
 
 type HashPair struct {
 	Key		Object
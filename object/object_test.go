@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value:"Hello World"}
@@ -19,4 +24,245 @@ func TestStringHashKey(t *testing.T) {
 	if hello1.HashKey() == diff1.HashKey() {
 		t.Errorf("strings with different content have same hash keys")
 	}
-}
\ No newline at end of file
+}
+// A key type whose keys all have the same HashKey
+type collidingKey struct {
+	name	string
+}
+
+func (k *collidingKey) Type() ObjectType {return "COLLIDING"}
+func (k *collidingKey) Inspect() string {return k.name}
+func (k *collidingKey) HashKey() HashKey {return HashKey{ObjectType: "COLLIDING", Value: 42}}
+func (k *collidingKey) Equal(other Object) bool {
+	o, ok := other.(*collidingKey)
+	return ok && k.name == o.name
+}
+
+func TestHash(t *testing.T) {
+	hash := NewHash()
+	a, b := &collidingKey{"a"}, &collidingKey{"b"}
+
+	hash.Set(a, &String{Value: "first"})
+	hash.Set(&Integer{Value: 1}, &String{Value: "one"})
+	hash.Set(b, &String{Value: "second"})
+	hash.Set(&Float{Value: 1.0}, &String{Value: "uno"})
+
+	if hash.Inspect() != `{a: first, 1: uno, b: second}` {
+		t.Errorf("wrong hash. got=%s", hash.Inspect())
+	}
+	if hash.Len() != 3 {
+		t.Errorf("wrong length. got=%d", hash.Len())
+	}
+	// Beyond 2^53 the nearest float is not equal to the integer
+	hash.Set(&Integer{Value: 9007199254740993}, &String{Value: "big"})
+
+	tests := []struct {
+		key			Hashable
+		expected	string
+	} {
+		{a, "first"},
+		{b, "second"},
+		{&collidingKey{"a"}, "first"},
+		{&collidingKey{"c"}, ""},
+		{&Integer{Value: 1}, "uno"},
+		{&String{Value: "1"}, ""},
+		{&Integer{Value: 9007199254740993}, "big"},
+		{&Float{Value: 9007199254740992}, ""},
+	}
+
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if tt.expected == "" {
+			if ok {
+				t.Errorf("Get(%s) found %s", tt.key.Inspect(), value.Inspect())
+			}
+			continue
+		}
+		if !ok || value.Inspect() != tt.expected {
+			t.Errorf("Get(%s) = %v, %t, want %s", tt.key.Inspect(), value, ok, tt.expected)
+		}
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	half1 := &Float{Value: 0.5}
+	half2 := &Float{Value: 0.5}
+	three := &Float{Value: 3.0}
+
+	if half1.HashKey() != half2.HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+
+	if half1.HashKey() == three.HashKey() {
+		t.Errorf("floats with different value have same hash keys")
+	}
+
+	if three.HashKey() != (&Integer{Value: 3}).HashKey() {
+		t.Errorf("float 3.0 and integer 3 have different hash keys")
+	}
+
+	// -2^63 is an int64, 2^63 is not
+	if (&Float{Value: -1 << 63}).HashKey() != (&Integer{Value: math.MinInt64}).HashKey() {
+		t.Errorf("float -2^63 and the smallest integer have different hash keys")
+	}
+	if (&Float{Value: 1 << 63}).HashKey() == (&Integer{Value: math.MinInt64}).HashKey() {
+		t.Errorf("float 2^63 and the smallest integer have the same hash key")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value		float64
+		expected	string
+	} {
+		{3.14, "3.14"},
+		{3, "3.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestErrorStackTraceLimit(t *testing.T) {
+	err := &Error{Message: "stack overflow"}
+	for i := 0; i < 25; i++ {
+		err.Stack = append(err.Stack, StackFrame{Function: "f"})
+	}
+
+	lines := strings.Split(strings.TrimSuffix(err.StackTrace(), "\n"), "\n")
+	if len(lines) != maxStackTraceFrames + 1 {
+		t.Fatalf("wrong number of lines. want=%d, got=%d", maxStackTraceFrames + 1, len(lines))
+	}
+	if lines[0] != "\tat f (-)" {
+		t.Errorf("wrong first line. got=%q", lines[0])
+	}
+	if lines[len(lines)-1] != "\t... 5 more" {
+		t.Errorf("wrong last line. got=%q", lines[len(lines)-1])
+	}
+}
+
+func TestEvalContextLimits(t *testing.T) {
+	var none *EvalContext
+	if none.Step() != nil || none.Call() != nil || none.Allocate(&String{Value: "x"}) != nil || none.Reserve(1 << 40) != nil {
+		t.Fatalf("a nil context must not have limits")
+	}
+
+	ctx := NewEvalContext(context.Background(), Limits{MaxCallDepth: 2, MaxSteps: 2, MaxAllocations: 20})
+	if ctx.Call() != nil || ctx.Call() != nil {
+		t.Fatalf("calls within the limit failed")
+	}
+	if err := ctx.Call(); err == nil || err.Kind != CALL_DEPTH_ERROR {
+		t.Errorf("expected a call depth error. got=%v", err)
+	}
+	ctx.Return()
+	if ctx.Call() != nil {
+		t.Errorf("call after return failed")
+	}
+
+	ctx.Step()
+	ctx.Step()
+	if err := ctx.Step(); err == nil || err.Kind != STEP_LIMIT_ERROR {
+		t.Errorf("expected a step limit error. got=%v", err)
+	}
+
+	if ctx.Reserve(20) != nil {
+		t.Errorf("reserving the whole limit failed")
+	}
+	if err := ctx.Reserve(math.MaxInt64); err == nil || err.Kind != ALLOCATION_LIMIT_ERROR {
+		t.Errorf("expected an allocation limit error. got=%v", err)
+	}
+	if err := ctx.Allocate(&String{Value: "abcdefgh"}); err == nil || err.Kind != ALLOCATION_LIMIT_ERROR {
+		t.Errorf("expected an allocation limit error. got=%v", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cyclic := func() *Array {
+		a := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+		a.Elements[1] = a
+		return a
+	}
+	hash := NewHash()
+	hash.Set(&String{Value: "a"}, &Array{Elements: []Object{&Float{Value: 1}}})
+
+	tests := []struct {
+		a, b		Object
+		expected	bool
+	} {
+		{&Null{}, &Null{}, true},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Float{Value: math.NaN()}, &Float{Value: math.NaN()}, false},
+		{&String{Value: "a"}, &String{Value: "b"}, false},
+		{cyclic(), cyclic(), true},
+		{hash, hash, true},
+		{&collidingKey{"a"}, &collidingKey{"a"}, true},
+		{&Integer{Value: 1}, &String{Value: "1"}, false},
+		{&Integer{Value: 9007199254740993}, &Float{Value: 9007199254740992}, false},
+		{&Float{Value: 9007199254740992}, &Integer{Value: 9007199254740992}, true},
+		{&Integer{Value: math.MaxInt64}, &Float{Value: 1 << 63}, false},
+		{&Integer{Value: math.MinInt64}, &Float{Value: -1 << 63}, true},
+	}
+
+	if cyclic().Inspect() != "[1, [...]]" {
+		t.Errorf("wrong cyclic array. got=%s", cyclic().Inspect())
+	}
+	self := NewHash()
+	self.Set(&String{Value: "self"}, self)
+	self.Set(&String{Value: "array"}, cyclic())
+	if self.Inspect() != "{self: {...}, array: [1, [...]]}" {
+		t.Errorf("wrong cyclic hash. got=%s", self.Inspect())
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("test[%d]: Equal(%s, %s) = %t, want %t", i, tt.a.Inspect(), tt.b.Inspect(), got, tt.expected)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	array := func(values ...int64) *Array {
+		elements := make([]Object, len(values))
+		for i, v := range values {
+			elements[i] = &Integer{Value: v}
+		}
+		return &Array{Elements: elements}
+	}
+
+	tests := []struct {
+		a, b		Object
+		expected	int
+		ok			bool
+	} {
+		{&Integer{Value: 1}, &Float{Value: 1.5}, -1, true},
+		{&Float{Value: 2}, &Integer{Value: 2}, 0, true},
+		{&Float{Value: math.NaN()}, &Integer{Value: 2}, 0, false},
+		{&String{Value: "b"}, &String{Value: "a"}, 1, true},
+		{array(1, 2), array(1, 3), -1, true},
+		{array(1, 2, 0), array(1, 2), 1, true},
+		{array(), array(), 0, true},
+		{&Boolean{Value: true}, &Boolean{Value: false}, 0, false},
+		{NewHash(), NewHash(), 0, false},
+		{&Integer{Value: 1}, &String{Value: "1"}, 0, false},
+		{&Integer{Value: 9007199254740993}, &Float{Value: 9007199254740992}, 1, true},
+		{&Float{Value: 9007199254740992}, &Integer{Value: 9007199254740993}, -1, true},
+		{&Integer{Value: -2}, &Float{Value: -1.5}, -1, true},
+		{&Integer{Value: math.MaxInt64}, &Float{Value: math.Inf(1)}, -1, true},
+		{&Float{Value: math.Inf(-1)}, &Integer{Value: math.MinInt64}, -1, true},
+	}
+
+	for i, tt := range tests {
+		got, ok := Compare(tt.a, tt.b)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("test[%d]: Compare(%s, %s) = %d, %t, want %d, %t",
+				i, tt.a.Inspect(), tt.b.Inspect(), got, ok, tt.expected, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package object
+
+import (
+	"sort"
+	"strings"
+)
+
+// Registry holds the builtin functions of an interpreter.
+// A name with a dot puts the function in a namespace: math.sqrt
+// is the function sqrt of the namespace math, a hash of its functions
+type Registry struct {
+	functions	map[string]*Builtin		// by full name
+	namespaces	map[string]*Hash
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		functions: make(map[string]*Builtin),
+		namespaces: make(map[string]*Hash),
+	}
+}
+
+// Add the builtins under their names, replacing the ones registered before
+func (r *Registry) Register(builtins ...*Builtin) {
+	for _, b := range builtins {
+		if b.Name == "" {
+			panic("builtin without a name")
+		}
+		r.functions[b.Name] = b
+
+		dot := strings.LastIndex(b.Name, ".")
+		if dot < 0 {
+			continue
+		}
+
+		namespace := b.Name[:dot]
+		hash, ok := r.namespaces[namespace]
+		if !ok {
+			// Programs cannot replace the functions, the hash is shared
+			hash = NewHash()
+			hash.Frozen = true
+			r.namespaces[namespace] = hash
+		}
+
+		hash.Set(&String{Value: b.Name[dot+1:]}, b)
+	}
+}
+
+// The function or namespace a name in a program refers to
+func (r *Registry) Lookup(name string) (Object, bool) {
+	if b, ok := r.functions[name]; ok && !strings.Contains(name, ".") {
+		return b, true
+	}
+	if hash, ok := r.namespaces[name]; ok {
+		return hash, true
+	}
+	return nil, false
+}
+
+// The function registered under its full name, e.g. math.sqrt
+func (r *Registry) Function(name string) (*Builtin, bool) {
+	b, ok := r.functions[name]
+	return b, ok
+}
+
+// All registered functions, sorted by name
+func (r *Registry) Functions() []*Builtin {
+	functions := make([]*Builtin, 0, len(r.functions))
+	for _, b := range r.functions {
+		functions = append(functions, b)
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Name < functions[j].Name
+	})
+	return functions
+}
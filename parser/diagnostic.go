@@ -0,0 +1,37 @@
+package parser
+
+import "monkey/token"
+
+type Severity string
+
+const (
+	ERROR Severity = "error"		// the program cannot run
+	WARNING Severity = "warning"	// the program runs, but probably not as intended
+)
+
+// Diagnostic describes one problem in the source, for the repl and editors
+type Diagnostic struct {
+	Pos			token.Position
+	Severity	Severity
+	Message		string
+	Expected	token.TokenType		// the token that should have come, empty if none in particular
+	Actual		token.TokenType		// the token that was found instead, empty if not about a token
+	Hint		string				// how the problem might be fixed, may be empty
+}
+
+// Formats the diagnostic as pos: message, like the errors of the lexer and compiler
+func (d *Diagnostic) String() string {
+	return d.Pos.String() + ": " + d.Message
+}
+
+// Hints for tokens that are often forgotten
+var missingTokenHints = map[token.TokenType]string {
+	token.RPAREN:	"a ( is not closed",
+	token.RBRACE:	"a { is not closed",
+	token.RBRACKET:	"a [ is not closed",
+	token.IDENT:	"a name is needed here",
+	token.ASSIGN:	"let is written as let name = value",
+	token.LBRACE:	"bodies of functions, conditions and loops are written in { }",
+	token.COLON:	"hash entries are written as key: value",
+	token.IN:		"for loops are written as for (name in iterable) { ... }",
+}
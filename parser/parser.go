@@ -4,9 +4,9 @@ package parser
 // A Pratt's parser (Dealing with the Expressions)
 
 import (
-	"../ast"
-	"../lexer"
-	"../token"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/token"
 	"fmt"
 	"strconv"
 )
@@ -20,7 +20,9 @@ type Parser struct {
 	l *lexer.Lexer
 	curToken token.Token
 	peekToken token.Token
-	errors []string
+	errors []*Diagnostic
+	loopDepth int		// number of loops around the current statement, for break and continue
+	braceDepth int		// number of { not closed yet, up to and including curToken
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -29,7 +31,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:l,
-		errors : []string{},
+		errors : []*Diagnostic{},
 	}
 	p.nextToken()
 	p.nextToken()
@@ -37,12 +39,14 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, 			p.parseIdentifier)
 	p.registerPrefix(token.INT,   			p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, 			p.parseFloatLiteral)
 	p.registerPrefix(token.BANG,  			p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, 			p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE,  			p.parseBoolean)
 	p.registerPrefix(token.FALSE, 			p.parseBoolean)
 	p.registerPrefix(token.LPAREN, 			p.parseGroupedExpression)
 	p.registerPrefix(token.IF, 				p.parseIfExpression)
+	p.registerPrefix(token.TRY, 			p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, 		p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, 			p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, 		p.parseArrayLiteral)
@@ -57,8 +61,20 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, 			p.parseInfixExpression)
 	p.registerInfix(token.LT, 				p.parseInfixExpression)
 	p.registerInfix(token.GT, 				p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, 			p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, 			p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, 			p.parseInfixExpression)
+	p.registerInfix(token.POWER, 			p.parseInfixExpression)
+	p.registerInfix(token.AND, 				p.parseInfixExpression)
+	p.registerInfix(token.OR, 				p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, 			p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, 		p.parseIndexExpression)
+	p.registerInfix(token.DOT, 				p.parseMemberExpression)
+	p.registerInfix(token.ASSIGN, 			p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, 		p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, 	p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, 	p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, 	p.parseAssignExpression)
 	return p
 }
 
@@ -72,19 +88,46 @@ func (p *Parser) registerInfix (tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func (p *Parser) Errors() []string {
+// Problems found in the source, the errors of the lexer come first
+func (p *Parser) Errors() []*Diagnostic {
 	return p.errors
 }
 
 func (p *Parser) peekErrors(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+	d := p.addError(p.peekToken.Pos, "expected next token to be %s, got %s instead",
 			   t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	d.Expected = t
+	d.Actual = p.peekToken.Type
+	d.Hint = missingTokenHints[t]
+}
+
+// Record an error, the caller may fill in the details of the returned diagnostic.
+// A second error at the same position is a consequence of the first and dropped
+func (p *Parser) addError(pos token.Position, format string, a ...interface{}) *Diagnostic {
+	d := &Diagnostic{Pos: pos, Severity: ERROR, Message: fmt.Sprintf(format, a...)}
+
+	if n := len(p.errors); n > 0 && p.errors[n-1].Pos == pos {
+		return d
+	}
+	p.errors = append(p.errors, d)
+	return d
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// The lexer may be set to keep comments, they do not matter here
+	for p.peekToken.Type == token.COMMENT {
+		p.peekToken = p.l.NextToken()
+	}
+
+	switch p.curToken.Type {
+		case token.LBRACE:
+			p.braceDepth += 1
+		case token.RBRACE:
+			p.braceDepth -= 1
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -92,21 +135,90 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementOrSync()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
 	}
+
+	p.addLexerErrors()
 	return program
 }
 
+// Errors of the lexer come first, they usually cause the others. An unterminated
+// string or comment swallows the rest of the input, running into the end of input
+// is not reported again then
+func (p *Parser) addLexerErrors() {
+	lexerErrors := p.l.Errors()
+	if len(lexerErrors) == 0 {
+		return
+	}
+
+	errors := []*Diagnostic{}
+	swallowed := false
+	for _, e := range lexerErrors {
+		errors = append(errors, &Diagnostic{Pos: e.Pos, Severity: ERROR, Message: e.Message})
+		swallowed = swallowed || e.AtEOF
+	}
+	for _, d := range p.errors {
+		if !swallowed || d.Actual != token.EOF {
+			errors = append(errors, d)
+		}
+	}
+	p.errors = errors
+}
+
+// Parse a statement, and skip what is left of it when it has errors,
+// so one mistake does not cause a row of follow-on errors
+func (p *Parser) parseStatementOrSync() ast.Statement {
+	depth := p.braceDepth
+	if p.curTokenIs(token.LBRACE) {
+		depth -= 1
+	}
+
+	errorCount := len(p.errors)
+	stmt := p.parseStatement()
+	if len(p.errors) > errorCount {
+		p.synchronize(depth)
+	}
+	return stmt
+}
+
+// Move to the end of the current statement, which started at the given brace depth:
+// a semicolon, or the token before a } closing the block around the statement
+// or before the keyword starting the next statement
+func (p *Parser) synchronize(depth int) {
+	for !p.curTokenIs(token.EOF) && p.braceDepth >= depth {
+		if p.braceDepth == depth {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+
+			switch p.peekToken.Type {
+				case token.RBRACE, token.EOF, token.LET, token.RETURN,
+					token.WHILE, token.FOR, token.BREAK, token.CONTINUE, token.THROW:
+					return
+			}
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 		case token.LET:
 			return p.parseLetStatement()
 		case token.RETURN:
 			return p.parseReturnStatement()
+		case token.WHILE:
+			return p.parseWhileStatement()
+		case token.FOR:
+			return p.parseForStatement()
+		case token.BREAK, token.CONTINUE:
+			return p.parseLoopControlStatement()
+		case token.THROW:
+			return p.parseThrowStatement()
 		default:
 			return p.parseExpressionStatement()
 	}
@@ -127,6 +239,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
+	// Let functions know their own name, e.g. for recursive calls
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) { 
 		p.nextToken()
 	}
@@ -145,6 +262,91 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token:p.curToken, Value:p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth += 1
+	defer func() { p.loopDepth -= 1 }()
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	var stmt ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		stmt = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		stmt = &ast.ContinueStatement{Token: p.curToken}
+	}
+
+	if p.loopDepth == 0 {
+		p.addError(p.curToken.Pos, "%s outside of a loop", p.curToken.Literal)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser)curTokenIs(t token.TokenType) bool {
 	return p.curToken.Type == t
 }
@@ -200,8 +402,18 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	var d *Diagnostic
+	switch t {
+		case token.ILLEGAL:
+			d = p.addError(p.curToken.Pos, "unexpected character %q", p.curToken.Literal)
+		case token.EOF:
+			d = p.addError(p.curToken.Pos, "unexpected end of input, expected an expression")
+			d.Hint = "the input ends in the middle of an expression"
+		default:
+			d = p.addError(p.curToken.Pos, "no prefix parse function for %s found", t)
+			d.Hint = "an expression cannot start with " + string(t)
+	}
+	d.Actual = t
 }
 
 // Registered Parse Expression Functions
@@ -212,8 +424,20 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	// If base == 0, the base is implied by the string's prefix
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)	
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token:p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -262,11 +486,36 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	// Right associative, 2 ** 3 ** 2 is 2 ** 9
+	if p.curTokenIs(token.POWER) {
+		precedence -= 1
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression {
+		Token : p.curToken,
+		Target : target,
+		Operator : p.curToken.Literal,
+	}
+
+	switch target.(type) {
+		case *ast.Identifier, *ast.IndexExpression:
+		default:
+			d := p.addError(p.curToken.Pos, "cannot assign to %s", target)
+			d.Hint = "only names and index expressions like a[i] can be assigned"
+			return nil
+	}
+
+	// Right associative, a = b = 1 assigns 1 to b first
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value:p.curTokenIs(token.TRUE)}
 }
@@ -314,6 +563,64 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		d := p.addError(p.peekToken.Pos, "expected catch or finally after try block, got %s instead",
+			p.peekToken.Type)
+		d.Actual = p.peekToken.Type
+		d.Hint = "write try { ... } catch (e) { ... }"
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement{
 	block := &ast.BlockStatement{Token:p.curToken}
 	block.Statements = []ast.Statement{}
@@ -321,18 +628,32 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement{
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementOrSync()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
+
+	if p.curTokenIs(token.EOF) {
+		d := p.addError(p.curToken.Pos, "expected %s to close the block, got %s instead",
+			token.RBRACE, token.EOF)
+		d.Expected = token.RBRACE
+		d.Actual = token.EOF
+		d.Hint = missingTokenHints[token.RBRACE]
+	}
+	block.Rbrace = p.curToken
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token:p.curToken}
 
+	// Loops around the function do not reach into its body
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = loopDepth }()
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
@@ -376,6 +697,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression { Token:p.curToken, Function:function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -386,6 +708,7 @@ func (p *Parser) parseStringLiteral() ast.Expression{
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token:p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
 	return array
 }
 
@@ -413,13 +736,49 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Left:left, Token:p.curToken}
+	if p.peekTokenIs(token.COLON) {
+		return p.parseSliceExpression(exp, nil)
+	}
 	p.nextToken()
 
 	exp.Index = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.COLON) {
+		return p.parseSliceExpression(exp, exp.Index)
+	}
 	
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken
+	return exp
+}
+
+// a[low:high], the current token is the one before the colon
+func (p *Parser) parseSliceExpression(index *ast.IndexExpression, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token:index.Token, Left:index.Left, Low:low}
+	p.nextToken()
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	exp.Rbracket = p.curToken
+	return exp
+}
+
+// h.name is h["name"], e.g. math.sqrt for a function of a builtin namespace
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Left:left, Token:p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Index = &ast.StringLiteral{Token:p.curToken, Value:p.curToken.Literal}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
@@ -438,6 +797,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) { 
 			return nil
@@ -447,6 +807,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
 
@@ -454,24 +815,40 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 const (
 	_int = iota		// Auto-Increment, 0, 1, 2, ...
 	LOWEST			// 					1
-	EQUALS			// ==				2
-	LESSGREATER 	// < or >			3
-	SUM 			// +				4
-	PRODUCT 		// *				5
-	PREFIX			// -X or !X			6
-	CALL			// myFunction(X)	7
-	INDEX			// arr[1]			8
+	ASSIGN			// x = 1 or x += 1	2
+	OR				// ||				3
+	AND				// &&				4
+	EQUALS			// ==				5
+	LESSGREATER 	// < or >			6
+	SUM 			// +				7
+	PRODUCT 		// * or %			8
+	PREFIX			// -X or !X			9
+	POWER			// **, -2 ** 2 is -4	10
+	CALL			// myFunction(X)	11
+	INDEX			// arr[1] or h.key	12
 )
 
 var precedences = map[token.TokenType]int {
+	token.ASSIGN:			ASSIGN,
+	token.PLUS_ASSIGN:		ASSIGN,
+	token.MINUS_ASSIGN:		ASSIGN,
+	token.ASTERISK_ASSIGN:	ASSIGN,
+	token.SLASH_ASSIGN:		ASSIGN,
+	token.OR:			OR,
+	token.AND:			AND,
 	token.EQ: 			EQUALS,
 	token.NOT_EQ: 		EQUALS,
     token.LT:			LESSGREATER,
     token.GT:			LESSGREATER,
+	token.LT_EQ:		LESSGREATER,
+	token.GT_EQ:		LESSGREATER,
     token.PLUS:			SUM,
     token.MINUS:   		SUM,
     token.SLASH:    	PRODUCT,
 	token.ASTERISK: 	PRODUCT,
+	token.PERCENT:		PRODUCT,
+	token.POWER:		POWER,
 	token.LPAREN:   	CALL,
 	token.LBRACKET:		INDEX,
+	token.DOT:			INDEX,
 }
\ No newline at end of file
@@ -2,9 +2,11 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
-	"../ast"
-	"../lexer"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -382,6 +384,34 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
             "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a + b % c ** d ** e",
+			"(a + (b % (c ** (d ** e))))",
+		},
+		{
+			"-a ** b",
+			"(-(a ** b))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a == 1 && b != 2 || !c",
+			"(((a == 1) && (b != 2)) || (!c))",
+		},
+		{
+			"x = a && b",
+			"(x = (a && b))",
+		},
+		{
+			"-math.sqrt(x) * h.a.b",
+			"((-(math[sqrt])(x)) * ((h[a])[b]))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -700,8 +730,8 @@ func checkParserError(t *testing.T, p *Parser) {
 	}
 
 	t.Errorf("parser has %d errors", len(errors))
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, d := range errors {
+		t.Errorf("parser error: %q", d.String())
 	}
 	t.FailNow()
 }
@@ -813,6 +843,38 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"s[1:2]", "(s[1:2])"},
+		{"s[:n - 1]", "(s[:(n - 1)])"},
+		{"s[i + 1:]", "(s[(i + 1):])"},
+		{"s[:]", "(s[:])"},
+		{"a[0][1:][0]", "(((a[0])[1:])[0])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if stmt.Expression.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, stmt.Expression.String())
+		}
+	}
+
+	l := lexer.New("s[1:2] = \"x\"")
+	p := New(l)
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0].Message != "cannot assign to (s[1:2])" {
+		t.Errorf("slice assignment not rejected. got=%v", p.Errors())
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -913,4 +975,363 @@ func TestParsingHashLiteralsWithExpressions (t *testing.T) {
 
 		testFunc(value)
 	}
-}
\ No newline at end of file
+}
+
+func TestNodePositions(t *testing.T) {
+	input := "let add = fn(x, y) {\n  x + y\n};\nadd(1, [2])[0]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserError(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+
+	tests := []struct {
+		node		ast.Node
+		expectedPos	string
+		expectedEnd	string
+	} {
+		{program, "1:1", "4:15"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[1], "4:1", "4:15"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression).Left, "4:1", "4:12"},
+	}
+
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.expectedPos {
+			t.Errorf("test[%d] - pos wrong. expected=%s, got=%s",
+				i, tt.expectedPos, tt.node.Pos())
+		}
+		if tt.node.End().String() != tt.expectedEnd {
+			t.Errorf("test[%d] - end wrong. expected=%s, got=%s",
+				i, tt.expectedEnd, tt.node.End())
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	input := "let x = 5;\nlet = 10;"
+
+	l := lexer.NewWithFilename("test.mk", input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	expected := "test.mk:2:5: expected next token to be IDENT, got = instead"
+	if errors[0].String() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, errors[0].String())
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	float64
+	} {
+		{"3.14", 3.14},
+		{".5", 0.5},
+		{"1e-9", 1e-9},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"while (x < 10) { x }", "while (x < 10) x"},
+		{"for (x in [1, 2]) { break; continue; }", "for (x in [1, 2]) break;continue;"},
+		{"while (a) { fn() { 1 }; if (b) { break } }", "while a fn()1If b break;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"break;", "1:1: break outside of a loop"},
+		{"while (true) { fn() { continue } }", "1:23: continue outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 parser error, got=%d (%v)", len(errors), errors)
+		}
+		if errors[0].String() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0].String())
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"x = 5", "(x = 5)"},
+		{"a = b = 1", "(a = (b = 1))"},
+		{"x += 1 + 2 * 3", "(x += (1 + (2 * 3)))"},
+		{"a[i] -= 1", "((a[i]) -= 1)"},
+		{"h[\"k\"] /= 2; x *= 3", "((h[k]) /= 2)(x *= 3)"},
+		{"x = y == 1", "(x = (y == 1))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignTarget(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"1 = 2", "1:3: cannot assign to 1"},
+		{"f() += 1", "1:5: cannot assign to f()"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 parser error, got=%d (%v)", len(errors), errors)
+		}
+		if errors[0].String() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0].String())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `let x = 1; // one
+/* the /* nested */ sum */ x + /* inline */ 2`
+
+	l := lexer.New(input)
+	l.KeepComments()
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserError(t, p)
+
+	if program.String() != "let x = 1;(x + 2)" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	l := lexer.New("let x = 1 + /* not closed")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+	if errors[0].String() != "1:13: unterminated block comment" {
+		t.Errorf("wrong error. got=%q", errors[0].String())
+	}
+
+	// Errors at the end of input are kept when the lexer did not read up to it
+	l = lexer.New(`let s = "\q"; fn() {`)
+	p = New(l)
+	p.ParseProgram()
+
+	got := []string{}
+	for _, e := range p.Errors() {
+		got = append(got, e.String())
+	}
+	expected := []string{"1:10: unknown escape sequence \\q", "1:21: expected } to close the block, got EOF instead"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong errors. expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	[]string
+	} {
+		{
+			"let = 5; let y = 2; y",
+			[]string{"1:5: expected next token to be IDENT, got = instead"},
+		},
+		{
+			"let x 5;\nlet y = ;\nlet z = 3;",
+			[]string{
+				"1:7: expected next token to be =, got INT instead",
+				"2:9: no prefix parse function for ; found",
+			},
+		},
+		{
+			"fn(x) { let = 1; x }; let y = 2",
+			[]string{"1:13: expected next token to be IDENT, got = instead"},
+		},
+		{
+			"if (x { 1 } let y = 2; while (y) { y }",
+			[]string{"1:7: expected next token to be ), got { instead"},
+		},
+		{
+			"let f = fn(x) { x +",
+			[]string{"1:20: unexpected end of input, expected an expression"},
+		},
+		{
+			"while (true) { 1",
+			[]string{"1:17: expected } to close the block, got EOF instead"},
+		},
+		{
+			"let s = \"open",
+			[]string{"1:9: unterminated string literal"},
+		},
+		{
+			"let a = 1 & 2;\nlet b = 2",
+			[]string{"1:11: unexpected character \"&\""},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		got := []string{}
+		for _, d := range p.Errors() {
+			got = append(got, d.String())
+		}
+
+		if len(got) != len(tt.expected) {
+			t.Errorf("wrong errors for %q. expected=%q, got=%q", tt.input, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestDiagnosticDetails(t *testing.T) {
+	l := lexer.New("add(1, 2")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 diagnostic, got=%d (%v)", len(errors), errors)
+	}
+
+	d := errors[0]
+	if d.Severity != ERROR {
+		t.Errorf("wrong severity. got=%q", d.Severity)
+	}
+	if d.Expected != token.RPAREN || d.Actual != token.EOF {
+		t.Errorf("wrong tokens. expected=%q actual=%q", d.Expected, d.Actual)
+	}
+	if d.Hint != "a ( is not closed" {
+		t.Errorf("wrong hint. got=%q", d.Hint)
+	}
+	if d.Pos.String() != "1:9" {
+		t.Errorf("wrong position. got=%s", d.Pos)
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"try { x } catch (e) { y }", "try x catch (e) y"},
+		{"try { x } finally { z }", "try x finally z"},
+		{"let v = try { x } catch (err) { y } finally { z };", "let v = try x catch (err) y finally z;"},
+		{"throw \"boom\";", "throw boom;"},
+		{"fn() { throw error(x) }", "fn()throw error(x);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidTryExpressions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"try { x }; 1", "1:10: expected catch or finally after try block, got ; instead"},
+		{"try { x } catch { y }", "1:17: expected next token to be (, got { instead"},
+		{"try { x } catch (1) { y }", "1:18: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 parser error, got=%d (%v)", len(errors), errors)
+		}
+		if errors[0].String() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0].String())
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package repl
+// Commands of the console, e.g. :type 1 + 2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/token"
+)
+
+type command struct {
+	args	string		// what the command takes, shown by :help
+	help	string
+	run		func(s *session, arg string)
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"help": {"", "list the commands", (*session).help},
+		"builtins": {"", "list the builtin functions", (*session).listBuiltins},
+		"env": {"", "list the bindings of the session", (*session).listEnv},
+		"type": {"expr", "evaluate expr and show its type", (*session).showType},
+		"ast": {"expr", "show the tree expr is parsed to", (*session).showAST},
+		"tokens": {"expr", "show the tokens of expr", (*session).showTokens},
+		"load": {"file", "run the file in the session", (*session).load},
+		"reset": {"", "forget the bindings of the session", (*session).resetCommand},
+		"time": {"expr", "evaluate expr and show how long it took", (*session).timeEval},
+	}
+}
+
+// Run a line like :type x, the colon included
+func (s *session) runCommand(line string) {
+	name := strings.TrimPrefix(line, ":")
+	arg := ""
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name, arg = name[:i], strings.TrimSpace(name[i:])
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(s.out, "unknown command :%s, :help lists the commands\n", name)
+		return
+	}
+	if cmd.args != "" && arg == "" {
+		fmt.Fprintf(s.out, "usage: :%s %s\n", name, cmd.args)
+		return
+	}
+	cmd.run(s, arg)
+}
+
+func (s *session) help(arg string) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(s.out, "%-16s%s\n", strings.TrimSpace(":" + name + " " + cmd.args), cmd.help)
+	}
+}
+
+// One signature per line
+func (s *session) listBuiltins(arg string) {
+	for _, b := range s.builtins.Functions() {
+		fmt.Fprintln(s.out, b.Signature())
+	}
+}
+
+// One binding per line, functions only by their type
+func (s *session) listEnv(arg string) {
+	if s.engine == ENGINE_VM {
+		for _, symbol := range s.symbolTable.Symbols() {
+			s.printBinding(symbol.Name, s.globals[symbol.Index])
+		}
+		return
+	}
+	for _, name := range s.env.Names() {
+		value, _ := s.env.Get(name)
+		s.printBinding(name, value)
+	}
+}
+
+func (s *session) printBinding(name string, value object.Object) {
+	switch value.(type) {
+		case nil:
+			// defined by a program that failed before the binding
+			fmt.Fprintf(s.out, "%s\n", name)
+		case *object.Function, *object.CompiledFunction, *object.Closure, *object.Builtin:
+			fmt.Fprintf(s.out, "%s: %s\n", name, value.Type())
+		default:
+			fmt.Fprintf(s.out, "%s: %s = %s\n", name, value.Type(), value.Inspect())
+	}
+}
+
+func (s *session) showType(arg string) {
+	program := s.parse("", arg)
+	if program == nil {
+		return
+	}
+
+	evaluated := s.eval(program)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		s.print(errObj)
+		return
+	}
+	if evaluated == nil {
+		evaluated = evaluator.NULL
+	}
+	fmt.Fprintln(s.out, evaluated.Type())
+}
+
+func (s *session) showAST(arg string) {
+	program := s.parse("", arg)
+	if program == nil {
+		return
+	}
+	s.printTree("", "", reflect.ValueOf(program))
+}
+
+// Print the node and the nodes in its fields, indented below it
+func (s *session) printTree(indent string, label string, v reflect.Value) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return
+	}
+	node, ok := v.Interface().(ast.Node)
+	if !ok || v.IsNil() {
+		return
+	}
+
+	name := reflect.Indirect(v).Type().Name()
+	if _, isProgram := node.(*ast.Program); isProgram {
+		fmt.Fprintf(s.out, "%s%s%s\n", indent, label, name)
+	} else {
+		fmt.Fprintf(s.out, "%s%s%s %q (%s)\n", indent, label, name, node.TokenLiteral(), node.Pos())
+	}
+
+	indent += "  "
+	fields := reflect.Indirect(v)
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		label := fields.Type().Field(i).Name + ": "
+
+		switch field.Kind() {
+			case reflect.Slice:
+				for n := 0; n < field.Len(); n++ {
+					s.printTree(indent, label, field.Index(n))
+				}
+			case reflect.Map:
+				// Hash literal pairs, in the order of the source
+				keys := field.MapKeys()
+				sort.Slice(keys, func(i, j int) bool {
+					return keys[i].Interface().(ast.Node).Pos().Offset < keys[j].Interface().(ast.Node).Pos().Offset
+				})
+				for _, key := range keys {
+					s.printTree(indent, "Key: ", key)
+					s.printTree(indent + "  ", "Value: ", field.MapIndex(key))
+				}
+			case reflect.Interface, reflect.Ptr:
+				if field.CanInterface() {
+					s.printTree(indent, label, field)
+				}
+		}
+	}
+}
+
+// One token per line with its position
+func (s *session) showTokens(arg string) {
+	l := lexer.New(arg)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%-8s%-12s%q\n", tok.Pos, tok.Type, tok.Literal)
+	}
+	for _, err := range l.Errors() {
+		fmt.Fprintln(s.out, err)
+	}
+}
+
+// Errors are printed, the value of the file is not
+func (s *session) load(filename string) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+
+	program := s.parse(filename, string(src))
+	if program == nil {
+		return
+	}
+	if errObj, ok := s.eval(program).(*object.Error); ok {
+		s.print(errObj)
+	}
+}
+
+func (s *session) resetCommand(arg string) {
+	s.reset()
+}
+
+func (s *session) timeEval(arg string) {
+	program := s.parse("", arg)
+	if program == nil {
+		return
+	}
+
+	start := time.Now()
+	evaluated := s.eval(program)
+	elapsed := time.Since(start)
+
+	s.print(evaluated)
+	fmt.Fprintf(s.out, "took %s\n", elapsed)
+}
@@ -0,0 +1,281 @@
+package repl
+// Line editing on a terminal: arrow keys, history and Ctrl-C
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// History of the console, in the home directory
+const HISTORY_FILE = ".monkey_history"
+
+// Lines kept in the history, older ones are dropped
+const maxHistory = 1000
+
+// Keys that are read as control characters
+const (
+	keyCtrlA		= 1
+	keyCtrlB		= 2
+	keyCtrlC		= 3
+	keyCtrlD		= 4
+	keyCtrlE		= 5
+	keyCtrlF		= 6
+	keyBackspace	= 8
+	keyCtrlK		= 11
+	keyCtrlN		= 14
+	keyCtrlP		= 16
+	keyCtrlU		= 21
+	keyEscape		= 27
+	keyDelete		= 127
+)
+
+// Keys of escape sequences, e.g. ESC [ A for the up arrow
+const (
+	keyUp = iota + 0x10000
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDeleteForward
+	keyUnknown
+)
+
+type editor struct {
+	in			*bufio.Reader
+	out			io.Writer
+	fd			uintptr
+	terminal	bool		// put fd in raw mode while a line is read
+	history		[]string
+	historyFile	string		// empty if the history is not saved
+}
+
+func newEditor(in io.Reader, out io.Writer, fd uintptr) (*editor, error) {
+	// Fail early on terminals that cannot be put in raw mode
+	state, err := makeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	restoreTerminal(fd, state)
+
+	e := &editor{in: bufio.NewReader(in), out: out, fd: fd, terminal: true}
+	if home, err := os.UserHomeDir(); err == nil {
+		e.historyFile = filepath.Join(home, HISTORY_FILE)
+		e.loadHistory()
+	}
+	return e, nil
+}
+
+func (e *editor) loadHistory() {
+	data, err := ioutil.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history) - maxHistory:]
+	}
+}
+
+// Keep the line for the up arrow and append it to the history file
+func (e *editor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// Rewrite the history file without the lines that were dropped
+func (e *editor) Close() {
+	if e.historyFile == "" {
+		return
+	}
+	ioutil.WriteFile(e.historyFile, []byte(strings.Join(e.history, "\n") + "\n"), 0600)
+}
+
+// Read a line with the terminal in raw mode, editing it as the keys come in
+func (e *editor) ReadLine(prompt string) (string, error) {
+	if e.terminal {
+		state, err := makeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		defer restoreTerminal(e.fd, state)
+	}
+
+	var line []rune
+	pos := 0							// of the cursor in line
+	historyPos := len(e.history)		// the line being edited is after the history
+	var edited []rune					// the line being edited while browsing the history
+
+	showHistory := func(n int) {
+		if historyPos == len(e.history) {
+			edited = line
+		}
+		historyPos = n
+		if n == len(e.history) {
+			line = edited
+		} else {
+			line = []rune(e.history[n])
+		}
+		pos = len(line)
+	}
+
+	for {
+		e.refresh(prompt, line, pos)
+
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+			case '\r', '\n':
+				io.WriteString(e.out, "\r\n")
+				return string(line), nil
+			case keyCtrlC:
+				io.WriteString(e.out, "^C\r\n")
+				return "", ErrInterrupted
+			case keyCtrlD:
+				if len(line) == 0 {
+					io.WriteString(e.out, "\r\n")
+					return "", io.EOF
+				}
+				if pos < len(line) {
+					line = append(line[:pos:pos], line[pos+1:]...)
+				}
+			case keyDeleteForward:
+				if pos < len(line) {
+					line = append(line[:pos:pos], line[pos+1:]...)
+				}
+			case keyBackspace, keyDelete:
+				if pos > 0 {
+					line = append(line[:pos-1:pos-1], line[pos:]...)
+					pos -= 1
+				}
+			case keyLeft, keyCtrlB:
+				if pos > 0 {
+					pos -= 1
+				}
+			case keyRight, keyCtrlF:
+				if pos < len(line) {
+					pos += 1
+				}
+			case keyHome, keyCtrlA:
+				pos = 0
+			case keyEnd, keyCtrlE:
+				pos = len(line)
+			case keyCtrlU:
+				line = line[pos:]
+				pos = 0
+			case keyCtrlK:
+				line = line[:pos]
+			case keyUp, keyCtrlP:
+				if historyPos > 0 {
+					showHistory(historyPos - 1)
+				}
+			case keyDown, keyCtrlN:
+				if historyPos < len(e.history) {
+					showHistory(historyPos + 1)
+				}
+			default:
+				if key < keyUp && unicode.IsPrint(key) || key == '\t' {
+					line = append(line[:pos:pos], append([]rune{key}, line[pos:]...)...)
+					pos += 1
+				}
+		}
+	}
+}
+
+// Redraw the prompt and the line, then put the cursor back at pos
+func (e *editor) refresh(prompt string, line []rune, pos int) {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(line))
+	if back := len(line) - pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// A character, or one of the keys sent as an escape sequence
+func (e *editor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != keyEscape {
+		return r, err
+	}
+
+	// ESC [ or ESC O, then the key. Some keys end with ~, e.g. ESC [ 3 ~
+	if next, _, err := e.in.ReadRune(); err != nil || (next != '[' && next != 'O') {
+		return keyUnknown, err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return keyUnknown, err
+	}
+
+	switch r {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case 'C':
+			return keyRight, nil
+		case 'D':
+			return keyLeft, nil
+		case 'H':
+			return keyHome, nil
+		case 'F':
+			return keyEnd, nil
+	}
+	if r < '0' || r > '9' {
+		return keyUnknown, nil
+	}
+
+	code := string(r)
+	for {
+		if r, _, err = e.in.ReadRune(); err != nil {
+			return keyUnknown, err
+		}
+		if r == '~' {
+			break
+		}
+		if (r < '0' || r > '9') && r != ';' {
+			return keyUnknown, nil
+		}
+		code += string(r)
+	}
+	switch code {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDeleteForward, nil
+	}
+	return keyUnknown, nil
+}
@@ -0,0 +1,104 @@
+package repl
+// Input of the console: lines are read until they form a whole program
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"monkey/lexer"
+	"monkey/token"
+)
+
+// Prompt of the lines that continue an unfinished program
+const CONTINUATION_PROMPT = ".."
+
+// ErrInterrupted is returned by ReadLine when the input was canceled with Ctrl-C
+var ErrInterrupted = errors.New("interrupted")
+
+// A source of input lines, with or without line editing
+type lineReader interface {
+	ReadLine(prompt string) (string, error)		// io.EOF at the end of input
+	AddHistory(line string)
+	Close()
+}
+
+// Line editing on a terminal, plain lines from anything else
+func newLineReader(in io.Reader, out io.Writer) lineReader {
+	if f, ok := in.(interface{ Fd() uintptr }); ok && isTerminal(f.Fd()) {
+		if e, err := newEditor(in, out, f.Fd()); err == nil {
+			return e
+		}
+	}
+	return &plainReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+// Lines of a pipe or file, the prompts are still written for the console
+type plainReader struct {
+	scanner		*bufio.Scanner
+	out			io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *plainReader) AddHistory(line string) {}
+func (r *plainReader) Close() {}
+
+// Read lines until the braces, brackets and parens are closed and the strings
+// and comments ended. Ctrl-C drops the lines read so far with ErrInterrupted
+func readInput(lines lineReader) (string, error) {
+	prompt := PROMPT
+	var src []string
+
+	for {
+		line, err := lines.ReadLine(prompt)
+		if err == io.EOF && len(src) > 0 {
+			// Let the parser report what is missing
+			return strings.Join(src, "\n"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		lines.AddHistory(line)
+		src = append(src, line)
+		if !isIncomplete(strings.Join(src, "\n")) {
+			return strings.Join(src, "\n"), nil
+		}
+		prompt = CONTINUATION_PROMPT
+	}
+}
+
+// Whether src stops in the middle of a block, a literal or a call,
+// e.g. after the first line of a function
+func isIncomplete(src string) bool {
+	l := lexer.New(src)
+	depth := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+			case token.LPAREN, token.LBRACE, token.LBRACKET:
+				depth += 1
+			case token.RPAREN, token.RBRACE, token.RBRACKET:
+				depth -= 1
+		}
+	}
+	if depth > 0 {
+		return true
+	}
+
+	for _, err := range l.Errors() {
+		if strings.HasPrefix(err.Message, "unterminated ") {
+			return true
+		}
+	}
+	return false
+}
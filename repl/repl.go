@@ -2,52 +2,147 @@ package repl
 // REPL: More like a console, Read, Eval, Print, Loop
 
 import (
-	"bufio"
-	"fmt"
 	"io"
-	"../lexer"
-	"../parser"
-	"../evaluator"
-	"../object"
+	"strings"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/vm"
 )
 
 const PROMPT = ">>"
 
+// Engines that can run the programs
+const (
+	ENGINE_EVAL = "eval"		// tree-walking evaluator
+	ENGINE_VM = "vm"			// bytecode compiler and virtual machine
+)
+
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	StartWithEngine(in, out, ENGINE_EVAL)
+}
+
+// Lines are read until they form a whole program, e.g. a function over several
+// lines. On a terminal they can be edited, and are kept in the history file
+// Input starting with a colon is a command of the console, see :help
+func StartWithEngine(in io.Reader, out io.Writer, engine string) {
+	lines := newLineReader(in, out)
+	defer lines.Close()
 
+	s := newSession(out, engine)
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := readInput(lines)
+		if err == ErrInterrupted {
+			continue
+		}
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if strings.HasPrefix(strings.TrimSpace(line), ":") {
+			s.runCommand(strings.TrimSpace(line))
+			continue
+		}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-            printParserErrors(out, p.Errors())
+		program := s.parse("", line)
+		if program == nil {
 			continue
 		}
+		s.print(s.eval(program))
+	}
+}
+
+// State of the console, kept from one input to the next
+type session struct {
+	out			io.Writer
+	engine		string
+	builtins	*object.Registry
+	env			*object.Environment
+
+	// State of the vm engine
+	constants	[]object.Object
+	globals		[]object.Object
+	symbolTable	*compiler.SymbolTable
+}
+
+func newSession(out io.Writer, engine string) *session {
+	s := &session{out: out, engine: engine, builtins: evaluator.NewRegistry()}
+	s.reset()
+	return s
+}
+
+// Forget the bindings of the programs run so far
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.env.SetBuiltins(s.builtins)
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil && evaluated != evaluator.NULL {
-			io.WriteString(out, evaluated.Inspect())
-            io.WriteString(out, "\n")
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	s.symbolTable = compiler.NewSymbolTable()
+}
+
+// The program, nil after printing its syntax errors
+func (s *session) parse(filename string, src string) *ast.Program {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return nil
+	}
+	return program
+}
+
+// Run the program with the engine of the session
+func (s *session) eval(program *ast.Program) object.Object {
+	if s.engine == ENGINE_VM {
+		comp := compiler.NewWithState(s.symbolTable, s.constants)
+		comp.SetBuiltins(s.builtins)
+		evaluated := runCompiled(comp, program, s.globals)
+		s.constants = comp.Bytecode().Constants
+		return evaluated
+	}
+	return evaluator.Eval(program, s.env)
+}
+
+// Print the value, nothing for null, the stack trace for errors
+func (s *session) print(evaluated object.Object) {
+	if evaluated != nil && evaluated != evaluator.NULL {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+	if errObj, ok := evaluated.(*object.Error); ok {
+		io.WriteString(s.out, errObj.StackTrace())
+	}
+}
+
+// Compile and run the program on the vm, errors are returned as error objects
+func runCompiled(comp *compiler.Compiler, program *ast.Program, globals []object.Object) object.Object {
+	if err := comp.Compile(program); err != nil {
+		if compileErr, ok := err.(*compiler.Error); ok {
+			return &object.Error{Message: compileErr.Message, Pos: compileErr.Pos}
 		}
+		return &object.Error{Message: err.Error()}
 	}
+
+	machine := vm.NewWithGlobalsStore(comp.Bytecode(), globals)
+	if err := machine.Run(); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return machine.LastPoppedStackElem()
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, errors []*parser.Diagnostic) {
 	io.WriteString(out, MONKEY_FACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range errors {
+		io.WriteString(out, "\t"+d.String()+"\n")
+		if d.Hint != "" {
+			io.WriteString(out, "\t\thint: "+d.Hint+"\n")
+		}
 	}
 }
 
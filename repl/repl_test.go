@@ -0,0 +1,150 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	bool
+	} {
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n x }", false},
+		{"[1, 2,", true},
+		{"puts(1,\n 2", true},
+		{"\"two\nlines", true},
+		{"/* open", true},
+		{"1 + ", false},		// left to the parser
+		{"}", false},
+		{"\"a { b\"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isIncomplete(tt.input); got != tt.expected {
+			t.Errorf("isIncomplete(%q) = %t, want %t", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMultilineInput(t *testing.T) {
+	input := "let f = fn(x) {\n  x * 2\n};\nf(21)\n[1,\n2]\nlen(\"ab\""
+
+	for _, engine := range []string{ENGINE_EVAL, ENGINE_VM} {
+		var out bytes.Buffer
+		StartWithEngine(strings.NewReader(input), &out, engine)
+
+		expected := ">>....>>42\n>>..[1, 2]\n>>"
+		if !strings.HasPrefix(out.String(), expected) {
+			t.Errorf("[%s] wrong output. want prefix %q, got=%q", engine, expected, out.String())
+		}
+		// Input that is still open at the end is left to the parser
+		if !strings.Contains(out.String(), "1:9: expected next token to be ), got EOF instead") {
+			t.Errorf("[%s] missing parser error. got=%q", engine, out.String())
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	file, err := ioutil.TempFile("", "lib*.mk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("let add = fn(a, b) { a + b };\nlet boom = fn() { 1 + true };")
+	file.Close()
+
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"let x = 5; let s = \"hi\"\n:env", "s: STRING = hi\nx: INTEGER = 5\n"},
+		{":type 1 * 2.0", "FLOAT\n"},
+		{":type if (false) { 1 }", "NULL\n"},
+		{":type 1 + true", "ERROR: 1:1: type mismatch: INTEGER + BOOLEAN\n"},
+		{":tokens let y = \"a\";",
+			"1:1     LET         \"let\"\n1:5     IDENT       \"y\"\n1:7     =           \"=\"\n" +
+			"1:9     STRING      \"a\"\n1:12    ;           \";\"\n"},
+		{":ast -x * {\"a\": f(1)}",
+			"Program\n" +
+			"  Statements: ExpressionStatement \"-\" (1:1)\n" +
+			"    Expression: InfixExpression \"*\" (1:1)\n" +
+			"      Left: PrefixExpression \"-\" (1:1)\n" +
+			"        Right: Identifier \"x\" (1:2)\n" +
+			"      Right: HashLiteral \"{\" (1:6)\n" +
+			"        Key: StringLiteral \"a\" (1:7)\n" +
+			"          Value: CallExpression \"(\" (1:12)\n" +
+			"            Function: Identifier \"f\" (1:12)\n" +
+			"            Arguments: IntegerLiteral \"1\" (1:14)\n"},
+		{":load " + file.Name() + "\nadd(1, 2)\n:env", "3\nadd: FUNCTION\nboom: FUNCTION\n"},
+		{":load " + file.Name() + "\nboom()", "ERROR: " + file.Name() + ":2:19: type mismatch: INTEGER + BOOLEAN\n" +
+			"\tat boom (1:1)\n"},
+		{":load /nonexistent.mk", "open /nonexistent.mk: no such file or directory\n"},
+		{"let x = 1\n:reset\n:env\nx", "ERROR: 1:1: identifier not found: x\n"},
+		{":time 1 + 2", "3\ntook "},
+		{":type", "usage: :type expr\n"},
+		{":nope", "unknown command :nope, :help lists the commands\n"},
+		{":builtins", "all(array ARRAY, fn FUNCTION|BUILTIN)\nany(array ARRAY, fn FUNCTION|BUILTIN)\nbytes(s STRING)\n"},
+	}
+
+	for _, engine := range []string{ENGINE_EVAL, ENGINE_VM} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			StartWithEngine(strings.NewReader(tt.input), &out, engine)
+
+			got := strings.ReplaceAll(out.String(), PROMPT, "")
+			if !strings.HasPrefix(got, tt.expected) {
+				t.Errorf("[%s] %q: wrong output. want=%q, got=%q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		keys		string
+		expected	[]string
+	} {
+		{"abc\r", []string{"abc"}},
+		{"ac\x1b[Db\r", []string{"abc"}},				// left arrow
+		{"bc\x01a\x05d\r", []string{"abcd"}},			// Ctrl-A, Ctrl-E
+		{"abx\x7fc\r", []string{"abc"}},				// backspace
+		{"abc\x1b[H\x1b[3~\r", []string{"bc"}},			// home, delete
+		{"abcd\x1b[D\x1b[D\x0b\r", []string{"ab"}},		// Ctrl-K
+		{"héllo\x1b[D\x1b[D\x1b[D\x1b[D\x7f\r", []string{"éllo"}},
+		{"one\rtwo\r\x1b[A\x1b[A\r", []string{"one", "two", "one"}},
+		{"one\rtw\x1b[A\x1b[Bo\r", []string{"one", "two"}},
+		{"abc\x03def\r", []string{"<interrupted>", "def"}},
+		{"\x04", []string{"<eof>"}},
+	}
+
+	for _, tt := range tests {
+		e := &editor{in: bufio.NewReader(strings.NewReader(tt.keys)), out: ioutil.Discard}
+
+		var lines []string
+		for len(lines) < len(tt.expected) {
+			line, err := e.ReadLine(PROMPT)
+			switch err {
+				case nil:
+					e.AddHistory(line)
+				case ErrInterrupted:
+					line = "<interrupted>"
+				case io.EOF:
+					line = "<eof>"
+				default:
+					t.Fatalf("ReadLine failed: %v", err)
+			}
+			lines = append(lines, line)
+		}
+
+		if strings.Join(lines, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("keys %q: wrong lines. want=%q, got=%q", tt.keys, tt.expected, lines)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package repl
+// Scripts: programs that run from start to end without a console
+
+import (
+	"io"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+)
+
+// Run a whole program, e.g. a script file, with the global args bound to
+// the strings of args. Syntax errors and an error the program did not catch
+// are written to errOut and the result is false. The value of the last
+// statement is returned, like the console would print it
+func RunScript(filename string, src string, args []string, errOut io.Writer, engine string) (object.Object, bool) {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, d := range p.Errors() {
+			io.WriteString(errOut, d.String()+"\n")
+			if d.Hint != "" {
+				io.WriteString(errOut, "\thint: "+d.Hint+"\n")
+			}
+		}
+		return nil, false
+	}
+
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	argsArray := &object.Array{Elements: elements}
+
+	var evaluated object.Object
+	if engine == ENGINE_VM {
+		symbolTable := compiler.NewSymbolTable()
+		globals := make([]object.Object, vm.GlobalsSize)
+		globals[symbolTable.Define("args").Index] = argsArray
+
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		evaluated = runCompiled(comp, program, globals)
+	} else {
+		env := object.NewEnvironment()
+		env.Set("args", argsArray)
+		evaluated = evaluator.Eval(program, env)
+	}
+
+	if errObj, ok := evaluated.(*object.Error); ok {
+		io.WriteString(errOut, errObj.Inspect()+"\n")
+		io.WriteString(errOut, errObj.StackTrace())
+		return evaluated, false
+	}
+	return evaluated, true
+}
@@ -0,0 +1,39 @@
+package repl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		src			string
+		expected	string		// the result, or what was written to errOut
+		ok			bool
+	} {
+		{"#!/usr/bin/env monkey\nlen(args)", "2", true},
+		{"args[1] + args[0]", "ba", true},
+		{"let f = fn() { throw \"boom\" };\nf()", "ERROR: test.mk:1:16: boom\n\tat f (test.mk:2:1)\n", false},
+		{"try { throw \"boom\" } catch (e) { 1 }", "1", true},
+		{"let x = ;", "test.mk:1:9: no prefix parse function for ; found\n" +
+			"\thint: an expression cannot start with ;\n", false},
+	}
+
+	for _, engine := range []string{ENGINE_EVAL, ENGINE_VM} {
+		for _, tt := range tests {
+			var errOut bytes.Buffer
+			result, ok := RunScript("test.mk", tt.src, []string{"a", "b"}, &errOut, engine)
+
+			if ok != tt.ok {
+				t.Errorf("[%s] %q: wrong success. want=%t, got=%t (%s)", engine, tt.src, tt.ok, ok, errOut.String())
+				continue
+			}
+			if ok && result.Inspect() != tt.expected {
+				t.Errorf("[%s] %q: wrong result. want=%q, got=%q", engine, tt.src, tt.expected, result.Inspect())
+			}
+			if !ok && tt.expected != "" && errOut.String() != tt.expected {
+				t.Errorf("[%s] %q: wrong errors. want=%q, got=%q", engine, tt.src, tt.expected, errOut.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package repl
+// Without raw mode the console reads plain lines
+
+import "errors"
+
+type terminalState struct{}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (*terminalState, error) {
+	return nil, errors.New("raw mode is not supported")
+}
+
+func restoreTerminal(fd uintptr, state *terminalState) error {
+	return nil
+}
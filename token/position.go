@@ -0,0 +1,28 @@
+package token
+
+import "fmt"
+
+// Position describes a location in the source code.
+// Line and Column are 1-based, Offset is the 0-based byte offset.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// A position with Line 0 is the zero value, it points nowhere
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// Formats the position as file:line:col, or line:col when there is no file name
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
@@ -5,6 +5,8 @@ type TokenType string
 type Token struct {
 	Type TokenType
 	Literal string
+	Pos Position		// position of the first character of the token
+	End Position		// position right after the last character of the token
 }
 
 const (
@@ -14,7 +16,9 @@ const (
 	// identifiers + literals
 	IDENT = "IDENT"		// variables, function names
 	INT = "INT"			// 123456
+	FLOAT = "FLOAT"		// 3.14, 1e-9, .5
 	STRING = "STRING"
+	COMMENT = "COMMENT"	// only returned when the lexer keeps comments
 
 	// Operators
 	ASSIGN   = "="
@@ -23,16 +27,29 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
 	EQ     	 = "=="
     NOT_EQ 	 = "!="
 
+	PLUS_ASSIGN 	= "+="
+	MINUS_ASSIGN 	= "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN 	= "/="
+
 	LT = "<"
 	GT = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
+
+	AND = "&&"
+	OR = "||"
 	
 	// delimiter
 	COMMA = ","
 	SEMICOLON = ";"
 	COLON = ":"
+	DOT = "."
 
 	LPAREN = "("
 	RPAREN = ")"
@@ -49,6 +66,15 @@ const (
 	FALSE = "FALSE"
 	ELSE = "ELSE"
 	RETURN = "RETURN"
+	WHILE = "WHILE"
+	FOR = "FOR"
+	IN = "IN"
+	BREAK = "BREAK"
+	CONTINUE = "CONTINUE"
+	THROW = "THROW"
+	TRY = "TRY"
+	CATCH = "CATCH"
+	FINALLY = "FINALLY"
 )
 
 var keywords = map[string] TokenType{
@@ -59,6 +85,15 @@ var keywords = map[string] TokenType{
 	"else" : ELSE,
 	"return" : RETURN,
 	"if" : IF,
+	"while" : WHILE,
+	"for" : FOR,
+	"in" : IN,
+	"break" : BREAK,
+	"continue" : CONTINUE,
+	"throw" : THROW,
+	"try" : TRY,
+	"catch" : CATCH,
+	"finally" : FINALLY,
 }
 
 func LookUpIndent(indent string) TokenType {
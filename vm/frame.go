@@ -0,0 +1,21 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+)
+
+// Frame is the call of one closure
+type Frame struct {
+	cl			*object.Closure
+	ip			int			// instruction pointer inside this frame
+	basePointer	int			// stack pointer before the call, locals start here
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,696 @@
+package vm
+
+// A stack based virtual machine running the bytecode of the compiler
+
+import (
+	"context"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// The stack and the frames start small and grow with the calls,
+// the call depth limit of the EvalContext stops a runaway recursion
+const StackSize = 2048
+const MaxStackSize = 1 << 24
+const GlobalsSize = 65536
+const FramesSize = 1024
+
+type VM struct {
+	constants	[]object.Object
+
+	stack		[]object.Object
+	sp			int					// always points to the next free slot, top of stack is stack[sp-1]
+
+	globals		[]object.Object
+
+	frames		[]*Frame
+	framesIndex	int
+
+	handlers	[]handler			// try blocks being run, innermost last
+
+	base		int					// first frame of the run, above the builtin that called a function
+
+	ctx			*object.EvalContext	// cancellation and limits of the run
+
+	err			*object.Error		// runtime error that stopped the program
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions: bytecode.Positions,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, FramesSize)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants: bytecode.Constants,
+		stack: make([]object.Object, StackSize),
+		sp: 0,
+		globals: make([]object.Object, GlobalsSize),
+		frames: frames,
+		framesIndex: 1,
+		ctx: object.NewEvalContext(context.Background(), object.Limits{}),
+	}
+}
+
+// Stop the program with an error when ctx is canceled or one of its limits is reached
+func (vm *VM) SetContext(ctx *object.EvalContext) {
+	vm.ctx = ctx
+}
+
+// Keep the globals of a previous run, e.g. line after line in the REPL
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// The value of the last expression statement, or the runtime error
+// that stopped the program, the same the evaluator would return
+func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.err != nil {
+		return vm.err
+	}
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, make([]*Frame, len(vm.frames))...)
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex += 1
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex -= 1
+	return vm.frames[vm.framesIndex]
+}
+
+// Run the program. Monkey runtime errors do not make Run fail,
+// they stop the program and are returned by LastPoppedStackElem
+func (vm *VM) Run() error {
+	return vm.run()
+}
+
+// Run until the program ends or the frames above vm.base have returned
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	// A bug in the vm stops the program with an error object, like the evaluator
+	defer func() {
+		if r := recover(); r != nil {
+			errObj := newError("internal error: %v", r)
+			errObj.Pos = vm.currentFrame().cl.Fn.Positions[ip]
+			vm.err = errObj
+		}
+	}()
+
+	for vm.framesIndex > vm.base && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		frame := vm.currentFrame()
+		frame.ip += 1
+
+		ip = frame.ip
+		ins = frame.Instructions()
+		op = code.Opcode(ins[ip])
+
+		if errObj := vm.ctx.Step(); errObj != nil {
+			vm.raise(errObj, frame, ip)
+			return nil
+		}
+
+		// Set by instructions that can fail at runtime
+		var result object.Object
+
+		switch op {
+			case code.OpConstant:
+				constIndex := code.ReadUint16(ins[ip+1:])
+				frame.ip += 2
+				result = vm.push(vm.constants[constIndex])
+			case code.OpPop:
+				vm.pop()
+			case code.OpDup:
+				n := int(code.ReadUint8(ins[ip+1:]))
+				frame.ip += 1
+
+				start := vm.sp - n
+				for i := 0; i < n && result == nil; i++ {
+					result = vm.push(vm.stack[start+i])
+				}
+			case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+				code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+				code.OpGreaterEqual, code.OpLessEqual:
+				right := vm.pop()
+				left := vm.pop()
+				result = evaluator.EvalInfixExpression(infixOperators[op], left, right)
+				if !isError(result) {
+					result = vm.pushAllocated(result)
+				}
+			case code.OpMinus, code.OpBang:
+				right := vm.pop()
+				result = evaluator.EvalPrefixExpression(prefixOperators[op], right)
+				if !isError(result) {
+					result = vm.push(result)
+				}
+			case code.OpTrue:
+				result = vm.push(evaluator.TRUE)
+			case code.OpFalse:
+				result = vm.push(evaluator.FALSE)
+			case code.OpNull:
+				result = vm.push(evaluator.NULL)
+			case code.OpJump:
+				pos := int(code.ReadUint16(ins[ip+1:]))
+				frame.ip = pos - 1
+			case code.OpJumpNotTruthy:
+				pos := int(code.ReadUint16(ins[ip+1:]))
+				frame.ip += 2
+
+				condition := vm.pop()
+				if !evaluator.IsTruthy(condition) {
+					frame.ip = pos - 1
+				}
+			case code.OpSetGlobal:
+				globalIndex := code.ReadUint16(ins[ip+1:])
+				frame.ip += 2
+				vm.globals[globalIndex] = vm.pop()
+			case code.OpGetGlobal:
+				globalIndex := code.ReadUint16(ins[ip+1:])
+				frame.ip += 2
+				result = vm.push(vm.globals[globalIndex])
+			case code.OpSetLocal:
+				localIndex := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+
+				slot := frame.basePointer + int(localIndex)
+				if c, ok := vm.stack[slot].(*cell); ok {
+					c.value = vm.pop()
+				} else {
+					vm.stack[slot] = vm.pop()
+				}
+			case code.OpGetLocal:
+				localIndex := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+				result = vm.push(deref(vm.stack[frame.basePointer+int(localIndex)]))
+			case code.OpGetFree:
+				freeIndex := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+				result = vm.push(deref(frame.cl.Free[freeIndex]))
+			case code.OpSetFree:
+				freeIndex := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+				frame.cl.Free[freeIndex].(*cell).value = vm.pop()
+			case code.OpCaptureLocal:
+				localIndex := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+
+				slot := frame.basePointer + int(localIndex)
+				c, ok := vm.stack[slot].(*cell)
+				if !ok {
+					c = &cell{value: vm.stack[slot]}
+					vm.stack[slot] = c
+				}
+				result = vm.push(c)
+			case code.OpCaptureFree:
+				freeIndex := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+				result = vm.push(frame.cl.Free[freeIndex])
+			case code.OpCurrentClosure:
+				result = vm.push(frame.cl)
+			case code.OpArray:
+				numElements := int(code.ReadUint16(ins[ip+1:]))
+				frame.ip += 2
+
+				array := vm.buildArray(vm.sp-numElements, vm.sp)
+				vm.sp = vm.sp - numElements
+				result = vm.pushAllocated(array)
+			case code.OpHash:
+				numElements := int(code.ReadUint16(ins[ip+1:]))
+				frame.ip += 2
+
+				hash := vm.buildHash(vm.sp-numElements, vm.sp)
+				vm.sp = vm.sp - numElements
+				if !isError(hash) {
+					hash = vm.pushAllocated(hash)
+				}
+				result = hash
+			case code.OpIndex:
+				index := vm.pop()
+				left := vm.pop()
+				result = evaluator.EvalIndexExpression(left, index)
+				if !isError(result) {
+					result = vm.push(result)
+				}
+			case code.OpSlice:
+				high := vm.pop()
+				low := vm.pop()
+				left := vm.pop()
+				result = evaluator.EvalSliceExpression(left, low, high)
+				if !isError(result) {
+					result = vm.pushAllocated(result)
+				}
+			case code.OpSetIndex:
+				value := vm.pop()
+				index := vm.pop()
+				left := vm.pop()
+				result = evaluator.EvalIndexAssignment(left, index, value)
+				if !isError(result) {
+					result = vm.push(result)
+				}
+			case code.OpCall:
+				numArgs := code.ReadUint8(ins[ip+1:])
+				frame.ip += 1
+				result = vm.executeCall(int(numArgs))
+			case code.OpReturnValue:
+				returnValue := vm.pop()
+
+				// A return outside of any function ends the program
+				if vm.framesIndex == 1 {
+					frame.ip = len(ins) - 1
+					continue
+				}
+
+				returned := vm.popFrame()
+				vm.ctx.Return()
+				vm.sp = returned.basePointer - 1
+				result = vm.push(returnValue)
+			case code.OpReturn:
+				returned := vm.popFrame()
+				vm.ctx.Return()
+				vm.sp = returned.basePointer - 1
+				result = vm.push(evaluator.NULL)
+			case code.OpClosure:
+				constIndex := code.ReadUint16(ins[ip+1:])
+				numFree := code.ReadUint8(ins[ip+3:])
+				frame.ip += 3
+				result = vm.pushClosure(int(constIndex), int(numFree))
+			case code.OpIter:
+				items, errObj := evaluator.IterableItems(vm.pop())
+				if errObj != nil {
+					result = errObj
+				} else {
+					result = vm.push(&iterator{items: items})
+				}
+			case code.OpIterNext:
+				pos := int(code.ReadUint16(ins[ip+1:]))
+				frame.ip += 2
+
+				iter := vm.pop().(*iterator)
+				if iter.next >= len(iter.items) {
+					frame.ip = pos - 1
+				} else {
+					result = vm.push(iter.items[iter.next])
+					iter.next += 1
+				}
+			case code.OpSetupCatch, code.OpSetupFinally:
+				pos := int(code.ReadUint16(ins[ip+1:]))
+				frame.ip += 2
+
+				vm.handlers = append(vm.handlers, handler{
+					framesIndex: vm.framesIndex,
+					sp: vm.sp,
+					pos: pos,
+					catch: op == code.OpSetupCatch,
+				})
+			case code.OpPopTry:
+				vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			case code.OpThrow:
+				// A finally block throws the error it was given again
+				thrown := vm.pop()
+				if errObj, ok := thrown.(*object.Error); ok {
+					result = errObj
+				} else {
+					result = evaluator.ThrownError(thrown)
+				}
+			default:
+				def, err := code.Lookup(byte(op))
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("opcode %s not supported", def.Name)
+		}
+
+		if errObj, ok := result.(*object.Error); ok && !vm.raise(errObj, frame, ip) {
+			return nil
+		}
+	}
+	return nil
+}
+
+var infixOperators = map[code.Opcode]string {
+	code.OpAdd:			"+",
+	code.OpSub:			"-",
+	code.OpMul:			"*",
+	code.OpDiv:			"/",
+	code.OpEqual:		"==",
+	code.OpNotEqual:	"!=",
+	code.OpGreaterThan:	">",
+	code.OpLessThan:	"<",
+	code.OpGreaterEqual:	">=",
+	code.OpLessEqual:	"<=",
+	code.OpMod:			"%",
+	code.OpPow:			"**",
+}
+
+var prefixOperators = map[code.Opcode]string {
+	code.OpMinus:	"-",
+	code.OpBang:	"!",
+}
+
+// Push returns nil, or an error object when the stack is full
+func (vm *VM) push(o object.Object) object.Object {
+	if !vm.grow(vm.sp + 1) {
+		return stackOverflow()
+	}
+
+	// Variables that were never set, e.g. the variable of a loop
+	// that did not run or a global whose let failed in an earlier run
+	if o == nil {
+		o = evaluator.NULL
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp += 1
+	return nil
+}
+
+// Push a value that was just created, counting it against the allocation limit
+func (vm *VM) pushAllocated(o object.Object) object.Object {
+	if errObj := vm.ctx.Allocate(o); errObj != nil {
+		return errObj
+	}
+	return vm.push(o)
+}
+
+// Make room for size slots, false when that is more than MaxStackSize.
+// Builtins keep their arguments, the old stack stays valid for them
+func (vm *VM) grow(size int) bool {
+	if size <= len(vm.stack) {
+		return true
+	}
+	if size > MaxStackSize {
+		return false
+	}
+
+	n := len(vm.stack) * 2
+	if n < size {
+		n = size
+	}
+	if n > MaxStackSize {
+		n = MaxStackSize
+	}
+	stack := make([]object.Object, n)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return true
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp -= 1
+	return o
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) object.Object {
+	hash := object.NewHash()
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := object.AsHashable(key)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey, value)
+	}
+	return hash
+}
+
+func (vm *VM) executeCall(numArgs int) object.Object {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+		case *object.Closure:
+			return vm.callClosure(callee, numArgs)
+		case *object.Builtin:
+			return vm.callBuiltin(callee, numArgs)
+		default:
+			return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) object.Object {
+	// Extra arguments are ignored, like the evaluator does
+	if numArgs < cl.Fn.NumParameters {
+		return newError("wrong number of arguments. got=%d, want=%d",
+			numArgs, cl.Fn.NumParameters)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if !vm.grow(frame.basePointer + cl.Fn.NumLocals) {
+		return stackOverflow()
+	}
+
+	if errObj := vm.ctx.Call(); errObj != nil {
+		return errObj
+	}
+
+	// Clear the locals, a cell left behind by an earlier call would be written through
+	for i := frame.basePointer + numArgs; i < frame.basePointer + cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+
+	vm.pushFrame(frame)
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+// A try block being run, errors unwind the stack to where it started
+type handler struct {
+	framesIndex	int
+	sp			int
+	pos			int			// where the catch or finally code starts
+	catch		bool		// catch gets the error as a value, finally the error itself
+}
+
+// Record where the error happened and continue at the innermost try handler.
+// Returns false when nothing handles the error and the program stops
+func (vm *VM) raise(errObj *object.Error, frame *Frame, ip int) bool {
+	if !errObj.Pos.IsValid() {
+		errObj.Pos = frame.cl.Fn.Positions[ip]
+	}
+	errObj.Stack = append(errObj.Stack, vm.stackTrace()...)
+
+	if errObj.Aborts() || !vm.handleError(errObj) {
+		vm.err = errObj
+		return false
+	}
+	return true
+}
+
+// Continue at the handler of the innermost try block, if there is one.
+// The stack of the error keeps the calls that were left
+func (vm *VM) handleError(errObj *object.Error) bool {
+	// The try blocks of the frames below vm.base get the error from the builtin
+	if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].framesIndex <= vm.base {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	errObj.Stack = errObj.Stack[:len(errObj.Stack)-(h.framesIndex-vm.bottomFrame())]
+	for vm.framesIndex > h.framesIndex {
+		vm.popFrame()
+		vm.ctx.Return()
+	}
+	vm.sp = h.sp
+
+	if h.catch {
+		vm.push(evaluator.ErrorValue(errObj))
+	} else {
+		vm.push(errObj)
+	}
+	vm.currentFrame().ip = h.pos - 1
+	return true
+}
+
+// The calls of the running frames, innermost first, like the evaluator records them
+func (vm *VM) stackTrace() []object.StackFrame {
+	stack := []object.StackFrame{}
+
+	for i := vm.framesIndex - 1; i >= vm.bottomFrame(); i-- {
+		name := vm.frames[i].cl.Fn.Name
+		if name == "" {
+			name = object.ANONYMOUS_FUNCTION
+		}
+
+		// The ip of the caller is on the operand of its OpCall
+		caller := vm.frames[i-1]
+		pos := caller.cl.Fn.Positions[caller.ip-1]
+
+		stack = append(stack, object.StackFrame{Function: name, Pos: pos})
+	}
+	return stack
+}
+
+// The lowest frame in stack traces, the main program has no call
+func (vm *VM) bottomFrame() int {
+	if vm.base == 0 {
+		return 1
+	}
+	return vm.base
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) object.Object {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.CallWith(vm.ctx, vm.callFunction, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if isError(result) {
+		return result
+	}
+	if result == nil {
+		result = evaluator.NULL
+	}
+	return vm.pushAllocated(result)
+}
+
+// Call fn for a builtin, e.g. the function given to map. A closure runs
+// in a frame above the builtin's and its errors are returned to the builtin
+func (vm *VM) callFunction(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+		case *object.Closure:
+			return vm.runClosure(fn, args)
+		case *object.Builtin:
+			return fn.CallWith(vm.ctx, vm.callFunction, args...)
+		default:
+			return newError("not a function: %s", fn.Type())
+	}
+}
+
+func (vm *VM) runClosure(cl *object.Closure, args []object.Object) object.Object {
+	sp := vm.sp
+	base := vm.base
+	handlers := len(vm.handlers)
+
+	result := vm.push(cl)
+	for i := 0; i < len(args) && result == nil; i++ {
+		result = vm.push(args[i])
+	}
+	if result == nil {
+		result = vm.callClosure(cl, len(args))
+	}
+	if result != nil {
+		vm.sp = sp
+		return result
+	}
+
+	vm.base = vm.framesIndex - 1
+	err := vm.run()
+	if err != nil && vm.err == nil {
+		vm.err = newError("%s", err)
+	}
+
+	// Leave the frames of the call on an error, the builtin gets it
+	if vm.err != nil {
+		errObj := vm.err
+		vm.err = nil
+		for vm.framesIndex > vm.base {
+			vm.popFrame()
+			vm.ctx.Return()
+		}
+		vm.handlers = vm.handlers[:handlers]
+		vm.base = base
+		vm.sp = sp
+		return errObj
+	}
+
+	vm.base = base
+	return vm.pop()
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) object.Object {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return newError("not a function: %+v", constant)
+	}
+
+	// Free variables are cells, only the current closure is captured by value
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		value := vm.stack[vm.sp-numFree+i]
+		if _, ok := value.(*cell); !ok {
+			value = &cell{value: value}
+		}
+		free[i] = value
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+// State of a for loop, stored in a hidden variable
+type iterator struct {
+	items	[]object.Object
+	next	int				// index of the next item
+}
+
+func (it *iterator) Type() object.ObjectType {return "ITERATOR"}
+func (it *iterator) Inspect() string {return "iterator"}
+
+// A variable shared between a function and the closures it creates
+type cell struct {
+	value	object.Object
+}
+
+func (c *cell) Type() object.ObjectType {return "CELL"}
+func (c *cell) Inspect() string {return "cell"}
+
+func deref(o object.Object) object.Object {
+	if c, ok := o.(*cell); ok {
+		return c.value
+	}
+	return o
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// The stack of the vm is full, it fails like too deep recursion in the evaluator
+func stackOverflow() *object.Error {
+	return &object.Error{Message: "stack overflow", Kind: object.CALL_DEPTH_ERROR}
+}
+
+func isError(obj object.Object) bool {
+	if obj != nil {
+		return obj.Type() == object.ERROR_OBJ
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	int64
+	} {
+		{
+			`let fibonacci = fn(x) {
+				if (x == 0) { return 0; }
+				if (x == 1) { return 1; }
+				fibonacci(x - 1) + fibonacci(x - 2);
+			};
+			fibonacci(15);`,
+			610,
+		},
+		{
+			`let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) { return 0; }
+					countDown(x - 1);
+				};
+				countDown(1);
+			};
+			wrapper();`,
+			0,
+		},
+		{
+			`let newAdder = fn(a, b) {
+				fn(c) { fn(d) { a + b + c + d } };
+			};
+			newAdder(1, 2)(3)(4);`,
+			10,
+		},
+	}
+
+	for _, tt := range tests {
+		result := testRun(t, tt.input)
+
+		integer, ok := result.(*object.Integer)
+		if !ok {
+			t.Errorf("object is not Integer. got=%T (%+v)", result, result)
+			continue
+		}
+		if integer.Value != tt.expected {
+			t.Errorf("object has wrong value. got=%d, want=%d", integer.Value, tt.expected)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"fn(a, b) { a }(1)", "1:1: wrong number of arguments. got=1, want=2"},
+		{"let f = fn() { f() }; f()", "1:16: stack overflow"},
+		{"1(2)", "1:1: not a function: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		result := testRun(t, tt.input)
+
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error. got=%T (%+v)", result, result)
+			continue
+		}
+		if errObj.Pos.String() + ": " + errObj.Message != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errObj.Inspect())
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	// Identifiers have no digits, local i is x followed by two letters
+	name := func(i int) string {
+		return fmt.Sprintf("x%c%c", 'a' + i / 26, 'a' + i % 26)
+	}
+	locals := "let f = fn() {"
+	for i := 0; i < 300; i++ {
+		locals += fmt.Sprintf(" let %s = %d;", name(i), i)
+	}
+	locals += fmt.Sprintf(" [%s, %s, %s, %s] }; f()", name(0), name(255), name(256), name(299))
+
+	args := make([]string, 300)
+	for i := range args {
+		args[i] = "1"
+	}
+	call := "let f = fn(a) { a }; f(" + strings.Join(args, ", ") + ")"
+
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{locals, "1:3746: too many local variables: 257, the vm allows 256"},
+		{call, "1:22: too many arguments: 300, the vm allows 255"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parser.New(lexer.New(tt.input)).ParseProgram())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	registry := evaluator.NewRegistry()
+	registry.Register(&object.Builtin{
+		Name: "math.neg",
+		Params: []object.BuiltinParam{{Name: "n", Types: []object.ObjectType{object.INTEGER_OBJ}}},
+		Fn: func(args ...object.Object) object.Object {
+			return &object.Integer{Value: -args[0].(*object.Integer).Value}
+		},
+	})
+
+	tests := []struct {
+		input		string
+		expected	string
+	} {
+		{"math.neg(len(\"ab\"))", "-2"},
+		{"let m = math; m[\"neg\"](-3)", "3"},
+		{"math.neg(true)", "argument to `math.neg` must be INTEGER, got BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetBuiltins(registry)
+		if err := comp.Compile(parser.New(lexer.New(tt.input)).ParseProgram()); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		result := vm.LastPoppedStackElem()
+		if errObj, ok := result.(*object.Error); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error. want=%q, got=%q", tt.expected, errObj.Message)
+			}
+		} else if result.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+		}
+	}
+}
+
+func testRun(t *testing.T, input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return vm.LastPoppedStackElem()
+}